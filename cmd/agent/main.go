@@ -2,77 +2,284 @@ package main
 
 import (
 	"context"
-	"flag"
+	"crypto/rsa"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/caarlos0/env/v6"
 	"github.com/ustkit/cmas/internal/agent"
+	"github.com/ustkit/cmas/internal/agent/collectors"
 	"github.com/ustkit/cmas/internal/agent/config"
+	"github.com/ustkit/cmas/internal/agent/outbox"
+	"github.com/ustkit/cmas/internal/agent/retry"
+	"github.com/ustkit/cmas/internal/configloader"
+	"github.com/ustkit/cmas/internal/encryption"
+	pb "github.com/ustkit/cmas/internal/proto"
+	"github.com/ustkit/cmas/internal/tlsconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 func main() {
-	agentConfig := &config.Config{}
-	flag.StringVar(&agentConfig.Sever, "a", "localhost:8080", "server address")
-	flag.StringVar(&agentConfig.PollInterval, "p", "2s", "poll interval")
-	flag.StringVar(&agentConfig.ReportInterval, "r", "10s", "report interval")
-	flag.StringVar(&agentConfig.DataType, "t", "jsonbatch", "data type")
-	flag.StringVar(&agentConfig.Key, "k", "", "data signing key")
-	flag.Parse()
-
-	err := env.Parse(agentConfig)
+	agentConfig, err := config.Load(os.Args[1:])
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
 
-	pollInterval, err := time.ParseDuration(agentConfig.PollInterval)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	defer stop()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	metrics := agent.NewMetrics()
+
+	sender, err := newSender(agentConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	reportInterval, err := time.ParseDuration(agentConfig.ReportInterval)
+	sender.apply(&metrics)
+
+	registry, err := collectors.NewDefaultRegistry(agentConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
-	defer stop()
+	group := startCollectors(ctx, &metrics, registry)
+	stopWorkers := startWorkers(&metrics, agentConfig)
+	ticker := time.NewTicker(agentConfig.ReportInterval)
 
-	metrics := agent.NewMetrics()
+	for {
+		select {
+		case <-ticker.C:
+			sender.report(ctx, &metrics, agentConfig)
+		case <-hup:
+			newConfig, err := config.Load(os.Args[1:])
+			if err != nil {
+				log.Printf("reload: %s, keeping the current config", err)
 
-	metricRuntimeUpdater := func(ctx context.Context) {
-		ticker := time.NewTicker(pollInterval)
+				continue
+			}
 
-		for {
-			select {
-			case <-ticker.C:
-				err := metrics.RuntimeUpdate()
+			changes := configloader.Diff(agentConfig, newConfig)
+
+			// The sender is rebuilt even when the config is unchanged, which
+			// reads the key and certificate files again after a rotation.
+			// Everything that can fail is built before anything is swapped,
+			// so a bad config leaves the running agent untouched.
+			newSender, err := newSender(newConfig)
+			if err != nil {
+				log.Printf("reload: %s, keeping the current config", err)
+
+				continue
+			}
+
+			var newRegistry *collectors.Registry
+
+			if !reflect.DeepEqual(collectorSettings(agentConfig), collectorSettings(newConfig)) {
+				newRegistry, err = collectors.NewDefaultRegistry(newConfig)
 				if err != nil {
-					log.Print(err)
+					newSender.close()
+					log.Printf("reload: %s, keeping the current config", err)
+
+					continue
 				}
-			case <-ctx.Done():
-				ticker.Stop()
+			}
 
-				return
+			sender.close()
+			sender = newSender
+			sender.apply(&metrics)
+
+			if newRegistry != nil {
+				group.stop()
+				registry = newRegistry
+				group = startCollectors(ctx, &metrics, registry)
 			}
+
+			if newConfig.DataType != agentConfig.DataType || newConfig.RateLimit != agentConfig.RateLimit {
+				stopWorkers()
+				stopWorkers = startWorkers(&metrics, newConfig)
+			}
+
+			if newConfig.ReportInterval != agentConfig.ReportInterval {
+				ticker.Reset(newConfig.ReportInterval)
+			}
+
+			agentConfig = newConfig
+
+			if len(changes) == 0 {
+				log.Println("reload: config unchanged, key and certificate files reloaded")
+
+				continue
+			}
+
+			log.Printf("reload: %s", strings.Join(changes, "; "))
+		case <-ctx.Done():
+			ticker.Stop()
+			group.stop()
+
+			// ctx is already cancelled, so the last poll window is flushed
+			// under a fresh deadline before the workers are stopped.
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), agentConfig.ShutdownTimeout)
+			collectOnce(shutdownCtx, &metrics, registry)
+			sender.report(shutdownCtx, &metrics, agentConfig)
+			cancel()
+
+			stopWorkers()
+			sender.close()
+
+			return
+		}
+	}
+}
+
+// sender holds everything built from the config for delivering reports.
+type sender struct {
+	retry      retry.Policy
+	outbox     *outbox.Outbox
+	publicKey  *rsa.PublicKey
+	client     *http.Client
+	conn       *grpc.ClientConn
+	grpcClient pb.MetricsClient
+}
+
+func newSender(agentConfig *config.Config) (*sender, error) {
+	s := &sender{
+		retry: retry.Policy{
+			MaxAttempts: agentConfig.Retry.MaxAttempts,
+			BaseDelay:   agentConfig.Retry.BaseDelay,
+			MaxDelay:    agentConfig.Retry.MaxDelay,
+			Jitter:      agentConfig.Retry.Jitter,
+		},
+	}
+
+	var err error
+
+	if agentConfig.Outbox.Dir != "" {
+		s.outbox, err = outbox.New(agentConfig.Outbox.Dir, agentConfig.Outbox.MaxSize, agentConfig.Outbox.MaxAge)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if agentConfig.CryptoKey != "" {
+		s.publicKey, err = encryption.LoadPublicKey(agentConfig.CryptoKey)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	go metricRuntimeUpdater(ctx)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	grpcCredentials := insecure.NewCredentials()
 
-	metricGopsutilUpdater := func(ctx context.Context) {
-		ticker := time.NewTicker(pollInterval)
+	if agentConfig.TLS.On() {
+		transport.TLSClientConfig, err = tlsconfig.Client(agentConfig.TLS.CA, agentConfig.TLS.Cert, agentConfig.TLS.Key, agentConfig.TLS.ServerName)
+		if err != nil {
+			return nil, err
+		}
+
+		grpcCredentials = credentials.NewTLS(transport.TLSClientConfig)
+	}
+
+	s.client = &http.Client{Transport: transport, Timeout: agentConfig.ReportInterval}
+
+	if agentConfig.DataType == "grpc" {
+		s.conn, err = grpc.Dial(agentConfig.Sever, grpc.WithTransportCredentials(grpcCredentials))
+		if err != nil {
+			return nil, err
+		}
+
+		s.grpcClient = pb.NewMetricsClient(s.conn)
+	}
+
+	return s, nil
+}
+
+func (s *sender) apply(metrics *agent.Metrics) {
+	metrics.SetRetryPolicy(s.retry)
+	metrics.SetOutbox(s.outbox)
+	metrics.SetPublicKey(s.publicKey)
+}
+
+func (s *sender) report(ctx context.Context, metrics *agent.Metrics, agentConfig *config.Config) {
+	// Retries of one report must not overlap with the next one.
+	reportCtx, cancel := context.WithTimeout(ctx, agentConfig.ReportInterval)
+	defer cancel()
+
+	switch agentConfig.DataType {
+	case "jsonbatch":
+		err := metrics.SendBatch(reportCtx, s.client, agentConfig)
+		if err != nil {
+			log.Printf("send batch: %s", err)
+		}
+	case "grpc":
+		err := metrics.SendGRPC(reportCtx, s.grpcClient, agentConfig)
+		if err != nil {
+			log.Printf("send grpc: %s", err)
+		}
+	default:
+		metrics.Send(reportCtx, s.client, agentConfig)
+	}
+}
+
+func (s *sender) close() {
+	s.client.CloseIdleConnections()
+
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+func startWorkers(metrics *agent.Metrics, agentConfig *config.Config) (stop func()) {
+	if agentConfig.DataType == "plain" || agentConfig.DataType == "json" {
+		return metrics.StartWorkers(agentConfig.RateLimit)
+	}
+
+	return func() {}
+}
+
+// collectorSettings are the parts of the config the registry is built from;
+// the collectors are only restarted on reload when one of them changes.
+func collectorSettings(c *config.Config) []interface{} {
+	return []interface{}{
+		c.PollInterval, c.ReportInterval, c.Collectors, c.Processes,
+		c.Disk, c.Net, c.StatsD, c.Cgroup, c.CgroupRoot, c.PressureRoot,
+	}
+}
+
+type collectorGroup struct {
+	cancel context.CancelFunc
+	wg     *sync.WaitGroup
+}
+
+func startCollectors(ctx context.Context, metrics *agent.Metrics, registry *collectors.Registry) *collectorGroup {
+	ctx, cancel := context.WithCancel(ctx)
+	group := &collectorGroup{cancel: cancel, wg: &sync.WaitGroup{}}
+
+	metricCollector := func(collector collectors.Collector) {
+		defer group.wg.Done()
+
+		ticker := time.NewTicker(collector.Interval())
 
 		for {
 			select {
 			case <-ticker.C:
-				err := metrics.GopsutilUpdate()
+				values, err := collector.Collect(ctx)
 				if err != nil {
-					log.Print(err)
+					log.Printf("%s collector: %s", collector.Name(), err)
+
+					continue
 				}
+
+				metrics.Update(values)
 			case <-ctx.Done():
 				ticker.Stop()
 
@@ -81,31 +288,46 @@ func main() {
 		}
 	}
 
-	go metricGopsutilUpdater(ctx)
+	for _, collector := range registry.Enabled() {
+		group.wg.Add(1)
 
-	metricSender := func(ctx context.Context) {
-		client := &http.Client{}
-		client.Timeout = reportInterval
+		go metricCollector(collector)
 
-		ticker := time.NewTicker(reportInterval)
+		if listener, ok := collector.(collectors.Listener); ok {
+			group.wg.Add(1)
 
-		for {
-			select {
-			case <-ticker.C:
-				if agentConfig.DataType == "jsonbatch" {
-					metrics.SendBatch(ctx, client, agentConfig)
-				} else {
-					metrics.Send(ctx, client, agentConfig)
-				}
-			case <-ctx.Done():
-				ticker.Stop()
+			go func(name string) {
+				defer group.wg.Done()
 
-				return
-			}
+				err := listener.Listen(ctx)
+				if err != nil {
+					log.Printf("%s collector: %s", name, err)
+				}
+			}(collector.Name())
 		}
 	}
 
-	go metricSender(ctx)
+	return group
+}
+
+// collectOnce polls every enabled collector, so values gathered since the
+// last tick (StatsD aggregates in particular) make it into the final report.
+func collectOnce(ctx context.Context, metrics *agent.Metrics, registry *collectors.Registry) {
+	for _, collector := range registry.Enabled() {
+		values, err := collector.Collect(ctx)
+		if err != nil {
+			log.Printf("%s collector: %s", collector.Name(), err)
+
+			continue
+		}
+
+		metrics.Update(values)
+	}
+}
 
-	<-ctx.Done()
+// stop cancels the collectors and waits for them, so listeners have released
+// their sockets before a new group binds them again.
+func (g *collectorGroup) stop() {
+	g.cancel()
+	g.wg.Wait()
 }
@@ -1,9 +1,50 @@
 package main
 
 import (
+	"context"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/agent"
+	"github.com/ustkit/cmas/internal/agent/collectors"
+	"github.com/ustkit/cmas/internal/types"
 )
 
 func Test_main(t *testing.T) {
 	t.Skip()
 }
+
+type staticCollector struct {
+	name  string
+	value types.Gauge
+}
+
+func (c staticCollector) Name() string {
+	return c.name
+}
+
+func (c staticCollector) Interval() time.Duration {
+	return time.Hour
+}
+
+func (c staticCollector) Collect(ctx context.Context) ([]types.ValueJSON, error) {
+	value := c.value
+
+	return []types.ValueJSON{{ID: c.name, MType: agent.GAUGE, Value: &value}}, nil
+}
+
+func TestCollectOnce(t *testing.T) {
+	registry := collectors.NewRegistry()
+	require.NoError(t, registry.Register(staticCollector{name: "enabled", value: 1.5}))
+	require.NoError(t, registry.Register(staticCollector{name: "disabled", value: 2.5}))
+	require.NoError(t, registry.Enable("enabled"))
+
+	metrics := agent.NewMetrics()
+	collectOnce(context.Background(), &metrics, registry)
+
+	require.Contains(t, metrics.Values, "enabled")
+	assert.Equal(t, types.Gauge(1.5), metrics.Values["enabled"].GValue)
+	assert.NotContains(t, metrics.Values, "disabled")
+}
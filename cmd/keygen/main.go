@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/ustkit/cmas/internal/encryption"
+)
+
+func main() {
+	var (
+		bits       int
+		privateKey string
+		publicKey  string
+	)
+
+	flag.IntVar(&bits, "bits", 4096, "RSA key size")
+	flag.StringVar(&privateKey, "private", "private.pem", "private key file for the server")
+	flag.StringVar(&publicKey, "public", "public.pem", "public key file for the agent")
+	flag.Parse()
+
+	privatePEM, publicPEM, err := encryption.GenerateKeys(bits)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = os.WriteFile(privateKey, privatePEM, 0o600)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = os.WriteFile(publicKey, publicPEM, 0o644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+type accepted struct {
+	conn net.Conn
+	err  error
+}
+
+// sharedListener keeps a socket open across server restarts. Servers get a
+// view of it; shutting a server down closes only its view, so a reload that
+// toggles TLS on the same address hands the socket to the new server instead
+// of binding it again, which could fail and leave nothing listening.
+type sharedListener struct {
+	net.Listener
+
+	conns  chan accepted
+	closed chan struct{}
+	once   *sync.Once
+}
+
+func share(listener net.Listener) *sharedListener {
+	shared := &sharedListener{
+		Listener: listener,
+		conns:    make(chan accepted),
+		closed:   make(chan struct{}),
+		once:     &sync.Once{},
+	}
+
+	go shared.accept()
+
+	return shared
+}
+
+func (l *sharedListener) accept() {
+	for {
+		conn, err := l.Listener.Accept()
+
+		select {
+		case l.conns <- accepted{conn: conn, err: err}:
+		case <-l.closed:
+			if conn != nil {
+				conn.Close()
+			}
+
+			return
+		}
+
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+// Close closes the socket itself, ending every view.
+func (l *sharedListener) Close() error {
+	var err error
+
+	l.once.Do(func() {
+		close(l.closed)
+		err = l.Listener.Close()
+	})
+
+	return err
+}
+
+func (l *sharedListener) view() net.Listener {
+	return &listenerView{shared: l, done: make(chan struct{}), once: &sync.Once{}}
+}
+
+type listenerView struct {
+	shared *sharedListener
+	done   chan struct{}
+	once   *sync.Once
+}
+
+func (v *listenerView) Accept() (net.Conn, error) {
+	select {
+	case a := <-v.shared.conns:
+		return a.conn, a.err
+	case <-v.done:
+		return nil, net.ErrClosed
+	case <-v.shared.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (v *listenerView) Close() error {
+	v.once.Do(func() {
+		close(v.done)
+	})
+
+	return nil
+}
+
+func (v *listenerView) Addr() net.Addr {
+	return v.shared.Addr()
+}
@@ -2,39 +2,66 @@ package main
 
 import (
 	"context"
-	"flag"
+	"crypto/rsa"
+	"crypto/tls"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/caarlos0/env/v6"
+	"github.com/ustkit/cmas/internal/configloader"
+	"github.com/ustkit/cmas/internal/encryption"
+	pb "github.com/ustkit/cmas/internal/proto"
 	"github.com/ustkit/cmas/internal/server/config"
+	"github.com/ustkit/cmas/internal/server/handlers"
 	"github.com/ustkit/cmas/internal/server/repositories"
 	"github.com/ustkit/cmas/internal/server/router"
 	"github.com/ustkit/cmas/internal/server/tools"
+	"github.com/ustkit/cmas/internal/tlsconfig"
 	"github.com/ustkit/cmas/internal/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip"
 )
 
+// historyExpireInterval is how often samples past the history retention are
+// dropped from series that are no longer updated.
+const historyExpireInterval = time.Minute
+
 func main() {
-	serverConfig := &config.Config{}
-	flag.StringVar(&serverConfig.Address, "a", "localhost:8080", "server address")
-	flag.BoolVar(&serverConfig.Restore, "r", true, "restore data")
-	flag.StringVar(&serverConfig.StoreInterval, "i", "300s", "store interval")
-	flag.StringVar(&serverConfig.StoreFile, "f", "/tmp/cmas-metrics-db.json", "store file")
-	flag.StringVar(&serverConfig.Key, "k", "", "key")
-	flag.StringVar(&serverConfig.DataBaseDSN, "d", "", "database dsn")
-	flag.Parse()
-
-	err := env.Parse(serverConfig)
+	serverConfig, err := config.Load(os.Args[1:])
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+
+	privateKey, err := loadPrivateKey(serverConfig)
+	if err != nil {
+		log.Fatalf("crypto key: %s", err)
+	}
+
+	certificates := &tlsSwitch{}
+
+	if serverConfig.TLS.Cert != "" {
+		tlsConfig, err := tlsconfig.Server(serverConfig.TLS.Cert, serverConfig.TLS.Key, serverConfig.TLS.ClientCA)
+		if err != nil {
+			log.Fatalf("tls: %s", err)
+		}
+
+		certificates.store(tlsConfig)
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 	defer stop()
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
 	var repository types.MetricRepo
 
 	if serverConfig.DataBaseDSN == "" {
@@ -47,21 +74,23 @@ func main() {
 		}
 	}
 
-	defer repository.Close()
-
 	err = repository.Restore()
 	if err != nil {
 		log.Printf("restore data: %s", err)
 	}
 
-	if serverConfig.StoreInterval != "0" && serverConfig.StoreFile != "" {
+	saverDone := make(chan struct{})
+
+	if serverConfig.StoreInterval != 0 && serverConfig.StoreFile != "" {
 		metricSaver := func(ctx context.Context, storeInterval time.Duration) {
+			defer close(saverDone)
+
 			ticker := time.NewTicker(storeInterval)
 
 			for {
 				select {
 				case <-ticker.C:
-					err = repository.SaveToFile()
+					err := repository.SaveToFile()
 					if err != nil {
 						log.Println(err)
 						stop()
@@ -74,21 +103,342 @@ func main() {
 			}
 		}
 
-		storeInterval, err := time.ParseDuration(serverConfig.StoreInterval)
-		if err == nil {
-			go metricSaver(ctx, storeInterval)
+		go metricSaver(ctx, serverConfig.StoreInterval)
+	} else {
+		close(saverDone)
+	}
+
+	// Writes expire the history of the series they touch; the ticker
+	// catches the series that stopped reporting.
+	if serverConfig.History.Retention > 0 {
+		go func() {
+			ticker := time.NewTicker(historyExpireInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					err := repository.ExpireHistory(ctx)
+					if err != nil {
+						log.Printf("expire history: %s", err)
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	handler := &handlerSwitch{}
+	handler.store(router.NewRouter(serverConfig, repository, privateKey))
+
+	grpcHandler := handlers.NewGRPCHandler(serverConfig, repository)
+
+	listener, err := net.Listen("tcp", serverConfig.Address)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	httpListener := share(listener)
+	httpServer := serveHTTP(httpListener.view(), handler, certificates.serverConfig(serverConfig), stop)
+
+	var (
+		grpcListener *sharedListener
+		grpcServer   *grpc.Server
+	)
+
+	if serverConfig.GRPCAddress != "" {
+		listener, err := net.Listen("tcp", serverConfig.GRPCAddress)
+		if err != nil {
+			log.Fatalf("grpc: %s", err)
+		}
+
+		grpcListener = share(listener)
+		grpcServer = serveGRPC(grpcListener.view(), grpcHandler, certificates.serverConfig(serverConfig), stop)
+	}
+
+	for {
+		select {
+		case <-hup:
+			newConfig, err := config.Load(os.Args[1:])
+			if err != nil {
+				log.Printf("reload: %s, keeping the current config", err)
+
+				continue
+			}
+
+			keepStorageSettings(serverConfig, newConfig)
+
+			changes := configloader.Diff(serverConfig, newConfig)
+
+			// The key and certificate files are read again even when the
+			// config is unchanged: they may have been rotated in place.
+			// Everything that can fail is prepared before anything is
+			// swapped, so a bad config leaves the running server untouched.
+			newKey, err := loadPrivateKey(newConfig)
+			if err != nil {
+				log.Printf("reload: crypto key: %s, keeping the current config", err)
+
+				continue
+			}
+
+			var newTLS *tls.Config
+
+			if newConfig.TLS.Cert != "" {
+				newTLS, err = tlsconfig.Server(newConfig.TLS.Cert, newConfig.TLS.Key, newConfig.TLS.ClientCA)
+				if err != nil {
+					log.Printf("reload: tls: %s, keeping the current config", err)
+
+					continue
+				}
+			}
+
+			tlsToggled := (newConfig.TLS.Cert == "") != (serverConfig.TLS.Cert == "")
+			restartHTTP := tlsToggled || newConfig.Address != serverConfig.Address
+			restartGRPC := tlsToggled || newConfig.GRPCAddress != serverConfig.GRPCAddress
+
+			// A server restarted on the same address keeps its socket; new
+			// addresses are bound up front.
+			newHTTPListener, newGRPCListener := httpListener, grpcListener
+
+			if newConfig.Address != serverConfig.Address {
+				listener, err := net.Listen("tcp", newConfig.Address)
+				if err != nil {
+					log.Printf("reload: %s, keeping the current config", err)
+
+					continue
+				}
+
+				newHTTPListener = share(listener)
+			}
+
+			if newConfig.GRPCAddress != serverConfig.GRPCAddress {
+				newGRPCListener = nil
+
+				if newConfig.GRPCAddress != "" {
+					listener, err := net.Listen("tcp", newConfig.GRPCAddress)
+					if err != nil {
+						if newHTTPListener != httpListener {
+							newHTTPListener.Close()
+						}
+
+						log.Printf("reload: grpc: %s, keeping the current config", err)
+
+						continue
+					}
+
+					newGRPCListener = share(listener)
+				}
+			}
+
+			if newTLS != nil {
+				certificates.store(newTLS)
+			}
+
+			handler.store(router.NewRouter(newConfig, repository, newKey))
+			grpcHandler.SetConfig(newConfig)
+
+			if restartHTTP {
+				shutdown(httpServer, serverConfig.ShutdownTimeout)
+
+				if newHTTPListener != httpListener {
+					httpListener.Close()
+					httpListener = newHTTPListener
+				}
+
+				httpServer = serveHTTP(httpListener.view(), handler, certificates.serverConfig(newConfig), stop)
+			}
+
+			if restartGRPC {
+				if grpcServer != nil {
+					stopGRPC(grpcServer, serverConfig.ShutdownTimeout)
+					grpcServer = nil
+				}
+
+				if grpcListener != nil && grpcListener != newGRPCListener {
+					grpcListener.Close()
+				}
+
+				grpcListener = newGRPCListener
+
+				if grpcListener != nil {
+					grpcServer = serveGRPC(grpcListener.view(), grpcHandler, certificates.serverConfig(newConfig), stop)
+				}
+			}
+
+			serverConfig = newConfig
+
+			if len(changes) == 0 {
+				log.Println("reload: config unchanged, key and certificate files reloaded")
+
+				continue
+			}
+
+			log.Printf("reload: %s", strings.Join(changes, "; "))
+		case <-ctx.Done():
+			// Stop accepting requests and let the in-flight ones finish, so
+			// the final save below sees every accepted update.
+			shutdown(httpServer, serverConfig.ShutdownTimeout)
+			httpListener.Close()
+
+			if grpcServer != nil {
+				stopGRPC(grpcServer, serverConfig.ShutdownTimeout)
+				grpcListener.Close()
+			}
+
+			<-saverDone
+
+			if serverConfig.StoreFile != "" {
+				err := repository.SaveToFile()
+				if err != nil {
+					log.Printf("save data: %s", err)
+				}
+			}
+
+			err := repository.Close()
+			if err != nil {
+				log.Printf("close repository: %s", err)
+			}
+
+			return
+		}
+	}
+}
+
+func loadPrivateKey(serverConfig *config.Config) (*rsa.PrivateKey, error) {
+	if serverConfig.CryptoKey == "" {
+		return nil, nil
+	}
+
+	return encryption.LoadPrivateKey(serverConfig.CryptoKey)
+}
+
+// keepStorageSettings restores the settings the repository was built with:
+// changing them needs a restart, so a reload only reports them.
+func keepStorageSettings(current, next *config.Config) {
+	if next.DataBaseDSN != current.DataBaseDSN || next.StoreFile != current.StoreFile ||
+		next.StoreInterval != current.StoreInterval || next.Restore != current.Restore ||
+		next.BatchTTL != current.BatchTTL || next.History != current.History {
+		log.Println("reload: storage settings need a restart and are left unchanged")
+	}
+
+	next.DataBaseDSN = current.DataBaseDSN
+	next.StoreFile = current.StoreFile
+	next.StoreInterval = current.StoreInterval
+	next.Restore = current.Restore
+	next.BatchTTL = current.BatchTTL
+	next.History = current.History
+}
+
+// handlerSwitch lets a reload replace the router under a running listener.
+type handlerSwitch struct {
+	handler atomic.Value
+}
+
+func (s *handlerSwitch) store(handler http.Handler) {
+	s.handler.Store(handler)
+}
+
+func (s *handlerSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.Load().(http.Handler).ServeHTTP(w, r)
+}
+
+// tlsSwitch hands out the current certificates on every handshake, so a
+// reload can rotate them without restarting the listeners.
+type tlsSwitch struct {
+	config atomic.Value
+}
+
+func (s *tlsSwitch) store(tlsConfig *tls.Config) {
+	s.config.Store(tlsConfig)
+}
+
+// serverConfig returns nil when TLS is off for serverConfig.
+func (s *tlsSwitch) serverConfig(serverConfig *config.Config) *tls.Config {
+	if serverConfig.TLS.Cert == "" {
+		return nil
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.config.Load().(*tls.Config), nil
+		},
+	}
+}
+
+func serveHTTP(listener net.Listener, handler http.Handler, tlsConfig *tls.Config, stop func()) *http.Server {
+	server := &http.Server{Handler: handler, TLSConfig: tlsConfig}
+
+	go func() {
+		var err error
+
+		if tlsConfig != nil {
+			err = server.ServeTLS(listener, "", "")
 		} else {
-			log.Printf("invalid store interval parameter: %s", err)
+			err = server.Serve(listener)
+		}
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println(err)
+			stop()
 		}
+	}()
+
+	return server
+}
+
+func serveGRPC(listener net.Listener, handler pb.MetricsServer, tlsConfig *tls.Config, stop func()) *grpc.Server {
+	options := []grpc.ServerOption{}
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
 	}
 
+	server := grpc.NewServer(options...)
+	pb.RegisterMetricsServer(server, handler)
+
 	go func() {
-		err := http.ListenAndServe(serverConfig.Address, router.NewRouter(serverConfig, repository))
+		err := server.Serve(listener)
 		if err != nil {
 			log.Println(err)
 			stop()
 		}
 	}()
 
-	<-ctx.Done()
+	return server
+}
+
+// shutdown closes the listener and waits up to timeout for in-flight
+// requests, then drops the connections that are still open.
+func shutdown(server *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("http shutdown: %s", err)
+		server.Close()
+	}
+}
+
+// stopGRPC is shutdown for the gRPC server: streams still open after
+// timeout are cancelled.
+func stopGRPC(server *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Println("grpc shutdown: timeout, closing open streams")
+		server.Stop()
+		<-done
+	}
 }
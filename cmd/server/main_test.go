@@ -1,9 +1,158 @@
 package main
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pb "github.com/ustkit/cmas/internal/proto"
+	"github.com/ustkit/cmas/internal/server/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 func Test_main(t *testing.T) {
 	t.Skip()
 }
+
+func TestHandlerSwitch(t *testing.T) {
+	handler := &handlerSwitch{}
+	handler.store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	handler.store(http.NotFoundHandler())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestKeepStorageSettings(t *testing.T) {
+	current := config.Default()
+	next := config.Default()
+	next.Key = "new"
+	next.StoreInterval = 0
+	next.DataBaseDSN = "postgres://localhost/metrics"
+
+	keepStorageSettings(current, next)
+
+	assert.Equal(t, "new", next.Key)
+	assert.Equal(t, current.StoreInterval, next.StoreInterval)
+	assert.Empty(t, next.DataBaseDSN)
+}
+
+func TestShutdownWaitsForInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := serveHTTP(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}), nil, func() {})
+
+	result := make(chan int, 1)
+
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			result <- 0
+
+			return
+		}
+		defer resp.Body.Close()
+
+		result <- resp.StatusCode
+	}()
+
+	<-started
+	shutdown(server, time.Second)
+
+	assert.Equal(t, http.StatusOK, <-result)
+
+	_, err = http.Get("http://" + listener.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestSharedListenerRestart(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	shared := share(listener)
+	defer shared.Close()
+
+	url := "http://" + shared.Addr().String()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	get := func() int {
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	server := serveHTTP(shared.view(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), nil, func() { t.Error("first server failed") })
+	assert.Equal(t, http.StatusOK, get())
+
+	shutdown(server, time.Second)
+
+	// The restarted server takes over the same socket.
+	server = serveHTTP(shared.view(), http.NotFoundHandler(), nil, func() { t.Error("second server failed") })
+	assert.Equal(t, http.StatusNotFound, get())
+
+	shutdown(server, time.Second)
+	require.NoError(t, shared.Close())
+
+	_, err = client.Get(url)
+	assert.Error(t, err)
+}
+
+type blockingMetricsServer struct {
+	pb.UnimplementedMetricsServer
+	started chan struct{}
+}
+
+func (s *blockingMetricsServer) StreamUpdates(stream pb.Metrics_StreamUpdatesServer) error {
+	close(s.started)
+	<-stream.Context().Done()
+
+	return stream.Context().Err()
+}
+
+func TestStopGRPCTimeout(t *testing.T) {
+	handler := &blockingMetricsServer{started: make(chan struct{})}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := serveGRPC(listener, handler, nil, func() {})
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = pb.NewMetricsClient(conn).StreamUpdates(context.Background())
+	require.NoError(t, err)
+
+	<-handler.started
+
+	start := time.Now()
+	stopGRPC(server, 50*time.Millisecond)
+
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -2,23 +2,30 @@ package agent
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/hmac"
+	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"math/rand"
 	"net/http"
-	"runtime"
+	neturl "net/url"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/mem"
-
+	"github.com/ustkit/cmas/internal/agent/collectors"
 	"github.com/ustkit/cmas/internal/agent/config"
+	"github.com/ustkit/cmas/internal/agent/outbox"
+	"github.com/ustkit/cmas/internal/agent/retry"
+	"github.com/ustkit/cmas/internal/encryption"
 	"github.com/ustkit/cmas/internal/types"
 )
 
@@ -30,167 +37,480 @@ const (
 type Metrics struct {
 	mu     *sync.Mutex
 	Values types.Values
+	outbox *outbox.Outbox
+	retry  retry.Policy
+	jobs   chan job
+	pubKey *rsa.PublicKey
+	// acked holds the counter totals already delivered (or handed over to
+	// the outbox); reports only carry the increments since then, because
+	// the server adds every delta it receives.
+	acked map[string]types.Counter
 }
 
 func NewMetrics() (metrics Metrics) {
 	metrics = Metrics{Values: make(map[string]*types.Value), mu: &sync.Mutex{}}
-	metrics.Values["Alloc"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["BuckHashSys"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["GCCPUFraction"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["GCSys"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["HeapAlloc"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["HeapIdle"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["HeapInuse"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["HeapObjects"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["HeapReleased"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["HeapSys"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["Lookups"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["MCacheInuse"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["MCacheSys"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["MSpanInuse"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["MSpanSys"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["Mallocs"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["NextGC"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["NumForcedGC"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["OtherSys"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["PauseTotalNs"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["StackInuse"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["StackSys"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["Sys"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["TotalAlloc"] = &types.Value{GValue: 0, TValue: "gauge"}
 	metrics.Values["PollCount"] = &types.Value{CValue: 0, TValue: "counter"}
 	metrics.Values["RandomValue"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["Frees"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["LastGC"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["NumGC"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["TotalMemory"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["FreeMemory"] = &types.Value{GValue: 0, TValue: "gauge"}
-	metrics.Values["CPUutilization1"] = &types.Value{GValue: 0, TValue: "gauge"}
 
 	return
 }
 
-func (metrics *Metrics) GopsutilUpdate() error {
-	virtMem, err := mem.VirtualMemory()
-	if err != nil {
-		return err
+func (metrics *Metrics) Update(values []types.ValueJSON) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	for _, valueJSON := range values {
+		value, ok := metrics.Values[valueJSON.ID]
+		if !ok {
+			value = &types.Value{TValue: valueJSON.MType}
+			metrics.Values[valueJSON.ID] = value
+		}
+
+		// A name keeps the type it was first reported with, e.g. a StatsD
+		// gauge can not take over a counter of the same name.
+		if value.TValue != valueJSON.MType {
+			log.Printf("metric %s: dropping %s value, already a %s", valueJSON.ID, valueJSON.MType, value.TValue)
+
+			continue
+		}
+
+		switch valueJSON.MType {
+		case GAUGE:
+			if valueJSON.Value != nil {
+				value.GValue = *valueJSON.Value
+			}
+		case COUNTER:
+			if valueJSON.Delta != nil {
+				value.CValue += *valueJSON.Delta
+			}
+		}
 	}
+}
 
-	cpuUtilization, err := cpu.Percent(time.Second, true)
-	if err != nil {
-		return err
+// Send posts every metric in its own request. With workers started the
+// requests are queued to them, blocking while the queue is full; otherwise
+// they are sent one by one. Counters carry the increment since their last
+// accepted request. Send returns once every request has finished or was
+// abandoned because ctx is done.
+func (metrics *Metrics) Send(ctx context.Context, client *http.Client, agentConfig *config.Config) {
+	metrics.mu.Lock()
+	rand.Seed(time.Now().UnixNano())
+	metrics.Values["PollCount"].CValue++
+	//nolint
+	metrics.Values["RandomValue"].GValue = types.Gauge(rand.Float64())
+
+	snapshot := make(map[string]types.Value, len(metrics.Values))
+	totals := make(map[string]types.Counter)
+
+	for name, value := range metrics.Values {
+		snapshot[name] = metrics.increment(name, value, totals)
 	}
 
+	labels := types.Labels(agentConfig.Labels)
+
+	jobs := metrics.jobs
+	policy := metrics.retry
+	metrics.mu.Unlock()
+
+	url := baseURL(agentConfig) + "/update/"
+
+	var (
+		wg         sync.WaitGroup
+		cancelled  int64
+		queueDepth int
+	)
+
+	for name, value := range snapshot {
+		mName, mValue := name, value
+		mValue.Labels = labels
+
+		j := job{
+			ctx: ctx,
+			send: func(ctx context.Context) error {
+				return policy.Do(ctx, func() error {
+					var (
+						req *http.Request
+						err error
+					)
+
+					switch agentConfig.DataType {
+					case "plain":
+						req, err = requestPlain(ctx, mName, &mValue, url)
+					case "json":
+						req, err = requestJSON(ctx, mName, &mValue, url, agentConfig.Key, agentConfig.GzipMinSize)
+					default:
+						err = fmt.Errorf("unknown data type %q", agentConfig.DataType)
+					}
+
+					if err != nil {
+						return err
+					}
+
+					return do(client, req)
+				})
+			},
+			done: func(err error) {
+				if err != nil {
+					log.Printf("send %s: %s", mName, err)
+
+					if ctx.Err() != nil {
+						atomic.AddInt64(&cancelled, 1)
+					}
+				} else if mValue.TValue == COUNTER {
+					metrics.ack(map[string]types.Counter{mName: totals[mName]})
+				}
+
+				wg.Done()
+			},
+		}
+
+		wg.Add(1)
+
+		if jobs == nil {
+			j.run()
+
+			continue
+		}
+
+		select {
+		case jobs <- j:
+			if depth := len(jobs); depth > queueDepth {
+				queueDepth = depth
+			}
+		case <-ctx.Done():
+			atomic.AddInt64(&cancelled, 1)
+			wg.Done()
+		}
+	}
+
+	wg.Wait()
+
+	metrics.Update([]types.ValueJSON{
+		collectors.Gauge("agent.send.queue_depth", float64(queueDepth)),
+		collectors.Counter("agent.send.cancelled", atomic.LoadInt64(&cancelled)),
+	})
+}
+
+func (metrics *Metrics) SetRetryPolicy(policy retry.Policy) {
 	metrics.mu.Lock()
 	defer metrics.mu.Unlock()
 
-	metrics.Values["TotalMemory"].GValue = types.Gauge(virtMem.Total)
-	metrics.Values["FreeMemory"].GValue = types.Gauge(virtMem.Free)
-	metrics.Values["CPUutilization1"].GValue = types.Gauge(cpuUtilization[0])
-
-	return nil
+	metrics.retry = policy
 }
 
-func (metrics *Metrics) RuntimeUpdate() error {
-	memStat := runtime.MemStats{}
-	runtime.ReadMemStats(&memStat)
+func (metrics *Metrics) SetOutbox(box *outbox.Outbox) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.outbox = box
+}
 
+// SetPublicKey makes SendBatch encrypt batches with the server's key.
+func (metrics *Metrics) SetPublicKey(key *rsa.PublicKey) {
 	metrics.mu.Lock()
 	defer metrics.mu.Unlock()
 
-	metrics.Values["Alloc"].GValue = types.Gauge(memStat.Alloc)
-	metrics.Values["BuckHashSys"].GValue = types.Gauge(memStat.BuckHashSys)
-	metrics.Values["GCCPUFraction"].GValue = types.Gauge(memStat.GCCPUFraction)
-	metrics.Values["GCSys"].GValue = types.Gauge(memStat.GCSys)
-	metrics.Values["HeapAlloc"].GValue = types.Gauge(memStat.HeapAlloc)
-	metrics.Values["HeapIdle"].GValue = types.Gauge(memStat.HeapIdle)
-	metrics.Values["HeapInuse"].GValue = types.Gauge(memStat.HeapInuse)
-	metrics.Values["HeapObjects"].GValue = types.Gauge(memStat.HeapObjects)
-	metrics.Values["HeapReleased"].GValue = types.Gauge(memStat.HeapReleased)
-	metrics.Values["HeapSys"].GValue = types.Gauge(memStat.HeapSys)
-	metrics.Values["Lookups"].GValue = types.Gauge(memStat.Lookups)
-	metrics.Values["MCacheInuse"].GValue = types.Gauge(memStat.MCacheInuse)
-	metrics.Values["MCacheSys"].GValue = types.Gauge(memStat.MCacheSys)
-	metrics.Values["Mallocs"].GValue = types.Gauge(memStat.Mallocs)
-	metrics.Values["NextGC"].GValue = types.Gauge(memStat.NextGC)
-	metrics.Values["NumForcedGC"].GValue = types.Gauge(memStat.NumForcedGC)
-	metrics.Values["OtherSys"].GValue = types.Gauge(memStat.OtherSys)
-	metrics.Values["PauseTotalNs"].GValue = types.Gauge(memStat.PauseTotalNs)
-	metrics.Values["StackInuse"].GValue = types.Gauge(memStat.StackInuse)
-	metrics.Values["StackSys"].GValue = types.Gauge(memStat.StackSys)
-	metrics.Values["Sys"].GValue = types.Gauge(memStat.Sys)
-	metrics.Values["TotalAlloc"].GValue = types.Gauge(memStat.TotalAlloc)
-	metrics.Values["Frees"].GValue = types.Gauge(memStat.Frees)
-	metrics.Values["LastGC"].GValue = types.Gauge(memStat.LastGC)
-	metrics.Values["NumGC"].GValue = types.Gauge(memStat.NumGC)
+	metrics.pubKey = key
+}
 
-	return nil
+// SendBatch posts all metrics in one request. With an outbox the batch is
+// queued on disk first and the whole queue is replayed in order, so batches
+// built while the server was unreachable are delivered once it is back.
+func (metrics *Metrics) SendBatch(ctx context.Context, client *http.Client, agentConfig *config.Config) error {
+	batch, totals, err := metrics.nextBatch(agentConfig)
+	if err != nil {
+		return err
+	}
+
+	metrics.mu.Lock()
+	policy := metrics.retry
+	pubKey := metrics.pubKey
+	metrics.mu.Unlock()
+
+	url := baseURL(agentConfig) + "/updates/"
+
+	return metrics.deliver(batch, totals, func(batch queuedBatch) error {
+		return policy.Do(ctx, func() error {
+			return postBatch(ctx, client, url, batch, agentConfig.GzipMinSize, pubKey)
+		})
+	})
 }
 
-func (metrics *Metrics) Send(ctx context.Context, client *http.Client, agentConfig *config.Config) {
+// nextBatch encodes the current report under a new batch ID along with the
+// counter totals it covers, which are acked once the batch is delivered.
+func (metrics *Metrics) nextBatch(agentConfig *config.Config) (queuedBatch, map[string]types.Counter, error) {
+	id, err := newBatchID()
+	if err != nil {
+		return queuedBatch{}, nil, err
+	}
+
 	metrics.mu.Lock()
 	defer metrics.mu.Unlock()
+
 	rand.Seed(time.Now().UnixNano())
 	metrics.Values["PollCount"].CValue++
 	//nolint
 	metrics.Values["RandomValue"].GValue = types.Gauge(rand.Float64())
 
-	url := "http://" + agentConfig.Sever + "/update/"
+	values := make(types.Values, len(metrics.Values))
+	totals := make(map[string]types.Counter)
 
 	for name, value := range metrics.Values {
-		name := name
-		value := value
-
-		go func(mName string, mValue *types.Value, url string) {
-			var (
-				req *http.Request
-				err error
-			)
-
-			switch agentConfig.DataType {
-			case "plain":
-				req, err = requestPlain(ctx, mName, mValue, url)
-				if err != nil {
-					return
-				}
-			case "json":
-				req, err = requestJSON(ctx, mName, mValue, url, agentConfig.Key)
-				if err != nil {
-					return
-				}
-			}
+		increment := metrics.increment(name, value, totals)
+		increment.Labels = types.Labels(agentConfig.Labels)
+		values[name] = &increment
+	}
 
-			resp, err := client.Do(req)
-			if err != nil {
-				return
-			}
+	body, err := encodeJSONBatch(values, agentConfig.Key)
 
-			resp.Body.Close()
-		}(name, value, url)
+	return queuedBatch{ID: id, Agent: agentConfig.ID, Metrics: body}, totals, err
+}
+
+// increment returns value as it is reported: counters carry what was added
+// since the last acked total, which is recorded in totals. It must be called
+// with metrics.mu held.
+func (metrics *Metrics) increment(name string, value *types.Value, totals map[string]types.Counter) types.Value {
+	reported := *value
+
+	if value.TValue == COUNTER {
+		totals[name] = value.CValue
+		reported.CValue -= metrics.acked[name]
 	}
+
+	return reported
 }
 
-func (metrics *Metrics) SendBatch(ctx context.Context, client *http.Client, agentConfig *config.Config) {
+func (metrics *Metrics) ack(totals map[string]types.Counter) {
 	metrics.mu.Lock()
 	defer metrics.mu.Unlock()
-	rand.Seed(time.Now().UnixNano())
-	metrics.Values["PollCount"].CValue++
-	//nolint
-	metrics.Values["RandomValue"].GValue = types.Gauge(rand.Float64())
 
-	url := "http://" + agentConfig.Sever + "/updates/"
+	if metrics.acked == nil {
+		metrics.acked = make(map[string]types.Counter, len(totals))
+	}
+
+	for name, total := range totals {
+		metrics.acked[name] = total
+	}
+}
+
+// deliver sends body and acks totals once the server accepted it. With an
+// outbox the totals are acked as soon as the batch is queued: from then on
+// the outbox is responsible for delivering those increments.
+func (metrics *Metrics) deliver(batch queuedBatch, totals map[string]types.Counter, send func(batch queuedBatch) error) error {
+	metrics.mu.Lock()
+	box := metrics.outbox
+	metrics.mu.Unlock()
 
-	req, err := requestJSONBatch(ctx, metrics.Values, url, agentConfig.Key)
+	if box == nil {
+		err := send(batch)
+		if err == nil {
+			metrics.ack(totals)
+		}
+
+		return err
+	}
+
+	data, err := json.Marshal(batch)
 	if err != nil {
-		return
+		return err
+	}
+
+	err = box.Append(data)
+	if err != nil {
+		return err
 	}
 
+	metrics.ack(totals)
+
+	return box.Replay(func(data []byte) error {
+		queued := queuedBatch{}
+
+		err := json.Unmarshal(data, &queued)
+		if err != nil {
+			log.Printf("outbox: dropping unreadable batch: %s", err)
+
+			return fmt.Errorf("%w: %s", outbox.ErrDrop, err)
+		}
+
+		err = send(queued)
+		if rejected(err) {
+			log.Printf("outbox: dropping batch rejected by server: %s", err)
+
+			return fmt.Errorf("%w: %s", outbox.ErrDrop, err)
+		}
+
+		return err
+	})
+}
+
+// rejected reports whether the server refused the batch itself, so sending
+// it again can never succeed.
+func rejected(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return !statusErr.Temporary()
+	}
+
+	var grpcErr *grpcError
+	if errors.As(err, &grpcErr) {
+		return grpcErr.rejected()
+	}
+
+	return false
+}
+
+// baseURL switches to https when any TLS option is set.
+func baseURL(agentConfig *config.Config) string {
+	if agentConfig.TLS.On() {
+		return "https://" + agentConfig.Sever
+	}
+
+	return "http://" + agentConfig.Sever
+}
+
+type StatusError struct {
+	StatusCode int
+	Status     string
+	After      time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return "unexpected response status: " + e.Status
+}
+
+// Temporary reports whether the request may succeed if sent again.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+func (e *StatusError) RetryAfter() time.Duration {
+	return e.After
+}
+
+// transportError marks failures to reach the server, which are always
+// worth retrying unless the request context itself is done.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+func (e *transportError) Temporary() bool {
+	return !errors.Is(e.err, context.Canceled) && !errors.Is(e.err, context.DeadlineExceeded)
+}
+
+func postBatch(ctx context.Context, client *http.Client, url string, batch queuedBatch, gzipMinSize int, pubKey *rsa.PublicKey) error {
+	req, err := newJSONRequest(ctx, url, batch.Metrics, gzipMinSize, pubKey)
+	if err != nil {
+		return err
+	}
+
+	if batch.ID != "" {
+		req.Header.Set(types.AgentIDHeader, batch.Agent)
+		req.Header.Set(types.BatchIDHeader, batch.ID)
+	}
+
+	return do(client, req)
+}
+
+// newJSONRequest gzips bodies of at least gzipMinSize bytes; a negative
+// gzipMinSize disables compression. With pubKey the (compressed) body is
+// encrypted, so the server decrypts first and then decompresses.
+func newJSONRequest(ctx context.Context, url string, body []byte, gzipMinSize int, pubKey *rsa.PublicKey) (*http.Request, error) {
+	compressed := gzipMinSize >= 0 && len(body) >= gzipMinSize
+
+	if compressed {
+		buf := &bytes.Buffer{}
+		zw := gzip.NewWriter(buf)
+
+		_, err := zw.Write(body)
+		if err != nil {
+			return nil, err
+		}
+
+		err = zw.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		body = buf.Bytes()
+	}
+
+	if pubKey != nil {
+		var err error
+
+		body, err = encryption.Encrypt(pubKey, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	if pubKey != nil {
+		req.Header.Set(encryption.Header, encryption.Scheme)
+	}
+
+	return req, nil
+}
+
+func do(client *http.Client, req *http.Request) error {
 	resp, err := client.Do(req)
 	if err != nil {
-		return
+		return &transportError{err: err}
+	}
+
+	defer resp.Body.Close()
+
+	_, err = io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return &transportError{err: err}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			After:      parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	return nil
+}
+
+// parseRetryAfter accepts both forms of the Retry-After header: a number of
+// seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
 	}
 
-	resp.Body.Close()
+	if date, err := http.ParseTime(value); err == nil {
+		if after := time.Until(date); after > 0 {
+			return after
+		}
+	}
+
+	return 0
 }
 
 func requestPlain(ctx context.Context, mName string, mValue *types.Value, url string) (req *http.Request, err error) {
@@ -203,7 +523,18 @@ func requestPlain(ctx context.Context, mName string, mValue *types.Value, url st
 		value = strconv.Itoa(int(mValue.CValue))
 	}
 
-	req, err = http.NewRequestWithContext(ctx, http.MethodPost, url+mValue.TValue+"/"+mName+"/"+value, &bytes.Buffer{})
+	target := url + mValue.TValue + "/" + mName + "/" + value
+
+	if len(mValue.Labels) > 0 {
+		query := neturl.Values{}
+		for name, value := range mValue.Labels {
+			query.Set(name, value)
+		}
+
+		target += "?" + query.Encode()
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, target, &bytes.Buffer{})
 	if err != nil {
 		return
 	}
@@ -213,8 +544,8 @@ func requestPlain(ctx context.Context, mName string, mValue *types.Value, url st
 	return
 }
 
-func requestJSON(ctx context.Context, mName string, mValue *types.Value, url, key string) (req *http.Request, err error) {
-	value := &types.ValueJSON{ID: mName, MType: mValue.TValue}
+func requestJSON(ctx context.Context, mName string, mValue *types.Value, url, key string, gzipMinSize int) (req *http.Request, err error) {
+	value := &types.ValueJSON{ID: mName, MType: mValue.TValue, Labels: mValue.Labels}
 
 	switch mValue.TValue {
 	case GAUGE:
@@ -235,21 +566,21 @@ func requestJSON(ctx context.Context, mName string, mValue *types.Value, url, ke
 		return
 	}
 
-	req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, body)
-	if err != nil {
-		return
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	return
+	return newJSONRequest(ctx, url, body.Bytes(), gzipMinSize, nil)
 }
 
-func requestJSONBatch(ctx context.Context, metrics types.Values, url, key string) (req *http.Request, err error) {
+func encodeJSONBatch(metrics types.Values, key string) ([]byte, error) {
 	values := make([]types.ValueJSON, 0, len(metrics))
 
 	for name, value := range metrics {
-		valueJSON := types.ValueJSON{ID: name, MType: value.TValue, Delta: &value.CValue, Value: &value.GValue}
+		valueJSON := types.ValueJSON{ID: name, MType: value.TValue, Labels: value.Labels}
+
+		switch value.TValue {
+		case GAUGE:
+			valueJSON.Value = &value.GValue
+		case COUNTER:
+			valueJSON.Delta = &value.CValue
+		}
 
 		if key != "" {
 			valueJSON.Hash = calcHash(name, value, key)
@@ -261,19 +592,12 @@ func requestJSONBatch(ctx context.Context, metrics types.Values, url, key string
 	body := &bytes.Buffer{}
 	encoder := json.NewEncoder(body)
 
-	err = encoder.Encode(values)
-	if err != nil {
-		return
-	}
-
-	req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	err := encoder.Encode(values)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-
-	return
+	return body.Bytes(), nil
 }
 
 func calcHash(mName string, mValue *types.Value, key string) string {
@@ -281,9 +605,9 @@ func calcHash(mName string, mValue *types.Value, key string) string {
 
 	switch mValue.TValue {
 	case GAUGE:
-		fmt.Fprintf(h, "%s:gauge:%f", mName, mValue.GValue)
+		fmt.Fprintf(h, "%s:gauge:%f", types.SeriesKey(mName, mValue.Labels), mValue.GValue)
 	case COUNTER:
-		fmt.Fprintf(h, "%s:counter:%d", mName, mValue.CValue)
+		fmt.Fprintf(h, "%s:counter:%d", types.SeriesKey(mName, mValue.Labels), mValue.CValue)
 	}
 
 	return hex.EncodeToString(h.Sum(nil))
@@ -1,10 +1,29 @@
 package agent
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/agent/config"
+	"github.com/ustkit/cmas/internal/agent/outbox"
+	"github.com/ustkit/cmas/internal/agent/retry"
+	"github.com/ustkit/cmas/internal/configloader"
+	"github.com/ustkit/cmas/internal/encryption"
+	serverconfig "github.com/ustkit/cmas/internal/server/config"
+	"github.com/ustkit/cmas/internal/server/middlewares"
+	"github.com/ustkit/cmas/internal/server/repositories"
+	"github.com/ustkit/cmas/internal/server/router"
 	"github.com/ustkit/cmas/internal/types"
 )
 
@@ -18,38 +37,8 @@ func TestCreate(t *testing.T) {
 			wantMetrics: Metrics{
 				mu: &sync.Mutex{},
 				Values: map[string]*types.Value{
-					"Alloc":           {GValue: 0, TValue: "gauge"},
-					"BuckHashSys":     {GValue: 0, TValue: "gauge"},
-					"GCCPUFraction":   {GValue: 0, TValue: "gauge"},
-					"GCSys":           {GValue: 0, TValue: "gauge"},
-					"HeapAlloc":       {GValue: 0, TValue: "gauge"},
-					"HeapIdle":        {GValue: 0, TValue: "gauge"},
-					"HeapInuse":       {GValue: 0, TValue: "gauge"},
-					"HeapObjects":     {GValue: 0, TValue: "gauge"},
-					"HeapReleased":    {GValue: 0, TValue: "gauge"},
-					"HeapSys":         {GValue: 0, TValue: "gauge"},
-					"Lookups":         {GValue: 0, TValue: "gauge"},
-					"MCacheInuse":     {GValue: 0, TValue: "gauge"},
-					"MCacheSys":       {GValue: 0, TValue: "gauge"},
-					"MSpanInuse":      {GValue: 0, TValue: "gauge"},
-					"MSpanSys":        {GValue: 0, TValue: "gauge"},
-					"Mallocs":         {GValue: 0, TValue: "gauge"},
-					"NextGC":          {GValue: 0, TValue: "gauge"},
-					"NumForcedGC":     {GValue: 0, TValue: "gauge"},
-					"OtherSys":        {GValue: 0, TValue: "gauge"},
-					"PauseTotalNs":    {GValue: 0, TValue: "gauge"},
-					"StackInuse":      {GValue: 0, TValue: "gauge"},
-					"StackSys":        {GValue: 0, TValue: "gauge"},
-					"Sys":             {GValue: 0, TValue: "gauge"},
-					"TotalAlloc":      {GValue: 0, TValue: "gauge"},
-					"PollCount":       {CValue: 0, TValue: "counter"},
-					"RandomValue":     {GValue: 0, TValue: "gauge"},
-					"Frees":           {GValue: 0, TValue: "gauge"},
-					"LastGC":          {GValue: 0, TValue: "gauge"},
-					"NumGC":           {GValue: 0, TValue: "gauge"},
-					"TotalMemory":     {GValue: 0, TValue: "gauge"},
-					"FreeMemory":      {GValue: 0, TValue: "gauge"},
-					"CPUutilization1": {GValue: 0, TValue: "gauge"},
+					"PollCount":   {CValue: 0, TValue: "counter"},
+					"RandomValue": {GValue: 0, TValue: "gauge"},
 				},
 			},
 		},
@@ -63,17 +52,539 @@ func TestCreate(t *testing.T) {
 	}
 }
 
-func TestRuntimeUpdate(t *testing.T) {
+func TestUpdate(t *testing.T) {
+	gauge := types.Gauge(12.5)
+	delta := types.Counter(3)
+
 	metrics := NewMetrics()
-	metrics.RuntimeUpdate()
-	counter := 0
-	for _, m := range metrics.Values {
-		if m.TValue == "gauge" && m.GValue != 0 {
-			counter++
+	metrics.Update([]types.ValueJSON{
+		{ID: "Alloc", MType: "gauge", Value: &gauge},
+		{ID: "Reads", MType: "counter", Delta: &delta},
+	})
+	metrics.Update([]types.ValueJSON{
+		{ID: "Reads", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "counter", Delta: &delta},
+	})
+
+	assert.Equal(t, &types.Value{GValue: 12.5, TValue: "gauge"}, metrics.Values["Alloc"])
+	assert.Equal(t, &types.Value{CValue: 6, TValue: "counter"}, metrics.Values["Reads"])
+}
+
+func TestSendBatch_Outbox(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		down     = true
+		received []string
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
 		}
-		if m.TValue == "counter" && m.CValue != 0 {
-			counter++
+
+		values := []types.ValueJSON{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&values))
+
+		for _, v := range values {
+			if v.ID == "PollCount" {
+				received = append(received, strconv.Itoa(int(*v.Delta)))
+			}
 		}
+	}))
+	defer ts.Close()
+
+	box, err := outbox.New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	metrics := NewMetrics()
+	metrics.SetOutbox(box)
+
+	agentConfig := &config.Config{Sever: strings.TrimPrefix(ts.URL, "http://"), GzipMinSize: -1}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		err = metrics.SendBatch(ctx, ts.Client(), agentConfig)
+		assert.Error(t, err)
 	}
-	assert.NotEqual(t, 0, counter)
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+
+	require.NoError(t, metrics.SendBatch(ctx, ts.Client(), agentConfig))
+
+	assert.Equal(t, []string{"1", "1", "1"}, received)
+
+	n, err := box.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestSendBatch_Retry(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "case 1",
+			statuses:  []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			wantCalls: 3,
+			wantErr:   false,
+		},
+		{
+			name:      "case 2",
+			statuses:  []int{http.StatusBadRequest, http.StatusOK},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "case 3",
+			statuses:  []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK},
+			wantCalls: 3,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[calls]
+				calls++
+
+				if status == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "0")
+				}
+
+				w.WriteHeader(status)
+			}))
+			defer ts.Close()
+
+			metrics := NewMetrics()
+			metrics.SetRetryPolicy(retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+			err := metrics.SendBatch(context.Background(), ts.Client(), &config.Config{Sever: strings.TrimPrefix(ts.URL, "http://")})
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantCalls, calls)
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+
+	after := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.Greater(t, after, 50*time.Second)
+}
+
+func TestSend_Workers(t *testing.T) {
+	var (
+		mu             sync.Mutex
+		inFlight, peak int
+		received       = make(map[string]bool)
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		received[r.URL.Path] = true
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	gauge := types.Gauge(1)
+	metrics := NewMetrics()
+
+	for i := 0; i < 20; i++ {
+		metrics.Update([]types.ValueJSON{{ID: "Metric" + strconv.Itoa(i), MType: "gauge", Value: &gauge}})
+	}
+
+	stop := metrics.StartWorkers(3)
+	defer stop()
+
+	agentConfig := &config.Config{Sever: strings.TrimPrefix(ts.URL, "http://"), DataType: "plain"}
+	metrics.Send(context.Background(), ts.Client(), agentConfig)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Len(t, received, 22)
+	assert.LessOrEqual(t, peak, 3)
+	assert.Equal(t, 0, inFlight)
+	assert.Contains(t, metrics.Values, "agent.send.queue_depth")
+}
+
+func TestSend_Cancel(t *testing.T) {
+	arrived := make(chan struct{}, 2)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	metrics := NewMetrics()
+	stop := metrics.StartWorkers(1)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		metrics.Send(ctx, ts.Client(), &config.Config{Sever: strings.TrimPrefix(ts.URL, "http://"), DataType: "json"})
+		close(done)
+	}()
+
+	// One request is in flight and the other is queued behind it.
+	<-arrived
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send did not return after cancel")
+	}
+
+	assert.Len(t, arrived, 0)
+	assert.Equal(t, types.Counter(2), metrics.Values["agent.send.cancelled"].CValue)
+}
+
+func TestSendBatch_Gzip(t *testing.T) {
+	tests := []struct {
+		name         string
+		gzipMinSize  int
+		wantEncoding string
+	}{
+		{name: "case 1", gzipMinSize: 0, wantEncoding: "gzip"},
+		{name: "case 2", gzipMinSize: 1 << 20, wantEncoding: ""},
+		{name: "case 3", gzipMinSize: -1, wantEncoding: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, tt.wantEncoding, r.Header.Get("Content-Encoding"))
+
+				var body io.Reader = r.Body
+				if r.Header.Get("Content-Encoding") == "gzip" {
+					zr, err := gzip.NewReader(r.Body)
+					require.NoError(t, err)
+					body = zr
+				}
+
+				values := []types.ValueJSON{}
+				assert.NoError(t, json.NewDecoder(body).Decode(&values))
+				assert.Len(t, values, 2)
+			}))
+			defer ts.Close()
+
+			metrics := NewMetrics()
+			agentConfig := &config.Config{Sever: strings.TrimPrefix(ts.URL, "http://"), GzipMinSize: tt.gzipMinSize}
+
+			require.NoError(t, metrics.SendBatch(context.Background(), ts.Client(), agentConfig))
+		})
+	}
+}
+
+func TestSendBatch_Encrypted(t *testing.T) {
+	privatePEM, publicPEM, err := encryption.GenerateKeys(2048)
+	require.NoError(t, err)
+	privateKey, err := encryption.ParsePrivateKey(privatePEM)
+	require.NoError(t, err)
+	publicKey, err := encryption.ParsePublicKey(publicPEM)
+	require.NoError(t, err)
+
+	received := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		values := []types.ValueJSON{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&values))
+		assert.Len(t, values, 2)
+		received++
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, encryption.Scheme, r.Header.Get(encryption.Header))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+
+		middlewares.Decrypt(privateKey, 0)(middlewares.Decompress(0)(handler)).ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	metrics := NewMetrics()
+	metrics.SetPublicKey(publicKey)
+	agentConfig := &config.Config{Sever: strings.TrimPrefix(ts.URL, "http://")}
+
+	require.NoError(t, metrics.SendBatch(context.Background(), ts.Client(), agentConfig))
+	assert.Equal(t, 1, received)
+}
+
+func TestSendBatch_TLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NotNil(t, r.TLS)
+	}))
+	defer ts.Close()
+
+	metrics := NewMetrics()
+	agentConfig := &config.Config{Sever: strings.TrimPrefix(ts.URL, "https://"), TLS: config.TLS{Enabled: true}}
+
+	require.NoError(t, metrics.SendBatch(context.Background(), ts.Client(), agentConfig))
+
+	agentConfig.TLS.Enabled = false
+	assert.Error(t, metrics.SendBatch(context.Background(), ts.Client(), agentConfig))
+}
+
+// flakyServer serves the real router but answers 503 to every third
+// request and to all of them while down is set. With lose set it applies
+// the requests and still answers 503, as if the responses were lost.
+type flakyServer struct {
+	mu    *sync.Mutex
+	down  bool
+	lose  bool
+	calls int
+	repo  repositories.RepoInMemory
+	*httptest.Server
+}
+
+func newFlakyServer() *flakyServer {
+	serverConfig := &serverconfig.Config{StoreInterval: time.Hour, BatchTTL: time.Hour}
+	repo := repositories.NewRepositoryInMemory(serverConfig)
+	handler := router.NewRouter(serverConfig, repo, nil)
+
+	fs := &flakyServer{mu: &sync.Mutex{}, repo: repo}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.mu.Lock()
+		fs.calls++
+		fail := fs.down || fs.calls%3 == 0
+		lose := fs.lose
+		fs.mu.Unlock()
+
+		switch {
+		case lose:
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case fail:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	}))
+
+	return fs
+}
+
+func (fs *flakyServer) setDown(down bool) {
+	fs.mu.Lock()
+	fs.down = down
+	fs.mu.Unlock()
+}
+
+func (fs *flakyServer) setLose(lose bool) {
+	fs.mu.Lock()
+	fs.lose = lose
+	fs.mu.Unlock()
+}
+
+func (fs *flakyServer) counter(t *testing.T, name string) types.Counter {
+	value, err := fs.repo.FindByName(context.Background(), name, nil)
+	require.NoError(t, err)
+
+	return value.CValue
+}
+
+func TestCounterTotals(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataType string
+	}{
+		{name: "case 1", dataType: "plain"},
+		{name: "case 2", dataType: "json"},
+		{name: "case 3", dataType: "jsonbatch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newFlakyServer()
+			defer ts.Close()
+
+			metrics := NewMetrics()
+			metrics.SetRetryPolicy(retry.Policy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+
+			agentConfig := &config.Config{Sever: strings.TrimPrefix(ts.URL, "http://"), DataType: tt.dataType}
+			report := func() {
+				if tt.dataType == "jsonbatch" {
+					_ = metrics.SendBatch(context.Background(), ts.Client(), agentConfig)
+				} else {
+					metrics.Send(context.Background(), ts.Client(), agentConfig)
+				}
+			}
+
+			for i := 1; i <= 10; i++ {
+				delta := types.Counter(i)
+				metrics.Update([]types.ValueJSON{{ID: "Requests", MType: "counter", Delta: &delta}})
+
+				ts.setDown(i%4 == 2)
+				report()
+			}
+
+			ts.setDown(false)
+			report()
+
+			assert.Equal(t, types.Counter(55), ts.counter(t, "Requests"))
+			assert.Equal(t, metrics.Values["PollCount"].CValue, ts.counter(t, "PollCount"))
+		})
+	}
+}
+
+func TestCounterTotals_Restart(t *testing.T) {
+	ts := newFlakyServer()
+	defer ts.Close()
+
+	dir := t.TempDir()
+	agentConfig := &config.Config{Sever: strings.TrimPrefix(ts.URL, "http://")}
+	delta := types.Counter(5)
+
+	box, err := outbox.New(dir, 0, 0)
+	require.NoError(t, err)
+
+	metrics := NewMetrics()
+	metrics.SetOutbox(box)
+	metrics.Update([]types.ValueJSON{{ID: "Requests", MType: "counter", Delta: &delta}})
+
+	ts.setDown(true)
+
+	for i := 0; i < 2; i++ {
+		assert.Error(t, metrics.SendBatch(context.Background(), ts.Client(), agentConfig))
+	}
+
+	// The restarted agent starts from zero and finds the queued batches.
+	box, err = outbox.New(dir, 0, 0)
+	require.NoError(t, err)
+
+	metrics = NewMetrics()
+	metrics.SetOutbox(box)
+	metrics.SetRetryPolicy(retry.Policy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+	metrics.Update([]types.ValueJSON{{ID: "Requests", MType: "counter", Delta: &delta}})
+
+	ts.setDown(false)
+	require.NoError(t, metrics.SendBatch(context.Background(), ts.Client(), agentConfig))
+
+	assert.Equal(t, types.Counter(10), ts.counter(t, "Requests"))
+	assert.Equal(t, types.Counter(3), ts.counter(t, "PollCount"))
+}
+
+func TestEncodeJSONBatch(t *testing.T) {
+	body, err := encodeJSONBatch(types.Values{
+		"Alloc": {GValue: 1.5, TValue: GAUGE},
+		"Reads": {CValue: 3, TValue: COUNTER},
+	}, "")
+	require.NoError(t, err)
+
+	values := []types.ValueJSON{}
+	require.NoError(t, json.Unmarshal(body, &values))
+	require.Len(t, values, 2)
+
+	for _, v := range values {
+		switch v.MType {
+		case GAUGE:
+			assert.Nil(t, v.Delta)
+			assert.Equal(t, types.Gauge(1.5), *v.Value)
+		case COUNTER:
+			assert.Nil(t, v.Value)
+			assert.Equal(t, types.Counter(3), *v.Delta)
+		}
+	}
+}
+
+func TestSendBatch_ResentBatchesAppliedOnce(t *testing.T) {
+	ts := newFlakyServer()
+	defer ts.Close()
+
+	dir := t.TempDir()
+	agentConfig := &config.Config{Sever: strings.TrimPrefix(ts.URL, "http://"), ID: "agent-1"}
+	delta := types.Counter(5)
+
+	box, err := outbox.New(dir, 0, 0)
+	require.NoError(t, err)
+
+	metrics := NewMetrics()
+	metrics.SetOutbox(box)
+	metrics.SetRetryPolicy(retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+	metrics.Update([]types.ValueJSON{{ID: "Requests", MType: "counter", Delta: &delta}})
+
+	// Every attempt is applied but none is acknowledged.
+	ts.setLose(true)
+	assert.Error(t, metrics.SendBatch(context.Background(), ts.Client(), agentConfig))
+
+	// The restarted agent replays the queued batch with the same IDs, even
+	// though its own ID has changed meanwhile.
+	agentConfig = &config.Config{Sever: agentConfig.Sever, ID: "agent-2"}
+
+	box, err = outbox.New(dir, 0, 0)
+	require.NoError(t, err)
+
+	metrics = NewMetrics()
+	metrics.SetOutbox(box)
+	metrics.SetRetryPolicy(retry.Policy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+	metrics.Update([]types.ValueJSON{{ID: "Requests", MType: "counter", Delta: &delta}})
+
+	ts.setLose(false)
+	require.NoError(t, metrics.SendBatch(context.Background(), ts.Client(), agentConfig))
+
+	assert.Equal(t, types.Counter(10), ts.counter(t, "Requests"))
+	assert.Equal(t, types.Counter(2), ts.counter(t, "PollCount"))
+}
+
+func TestSendBatch_Labels(t *testing.T) {
+	ts := newFlakyServer()
+	defer ts.Close()
+
+	for _, dataType := range []string{"jsonbatch", "json", "plain"} {
+		agentConfig := &config.Config{
+			Sever:    strings.TrimPrefix(ts.URL, "http://"),
+			DataType: dataType,
+			Labels:   configloader.Map{"host": dataType},
+		}
+
+		metrics := NewMetrics()
+		metrics.SetRetryPolicy(retry.Policy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+
+		if dataType == "jsonbatch" {
+			require.NoError(t, metrics.SendBatch(context.Background(), ts.Client(), agentConfig))
+		} else {
+			metrics.Send(context.Background(), ts.Client(), agentConfig)
+		}
+	}
+
+	values, err := ts.repo.FindAll(context.Background(), nil)
+	require.NoError(t, err)
+
+	for _, host := range []string{"jsonbatch", "json", "plain"} {
+		assert.Contains(t, values, `PollCount{host="`+host+`"}`)
+	}
+
+	assert.NotContains(t, values, "PollCount")
 }
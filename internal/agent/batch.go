@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// queuedBatch is a batch as the outbox stores it: the encoded metrics with
+// the agent and batch IDs they are sent under, so a replay, even after a
+// restart or a change of the agent ID, reuses them and the server can skip
+// a batch it has already applied.
+type queuedBatch struct {
+	ID      string          `json:"id"`
+	Agent   string          `json:"agent,omitempty"`
+	Metrics json.RawMessage `json:"metrics"`
+}
+
+func newBatchID() (string, error) {
+	id := make([]byte, 16)
+
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,244 @@
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ustkit/cmas/internal/types"
+)
+
+const (
+	CgroupAuto = "auto"
+	CgroupOn   = "on"
+	CgroupOff  = "off"
+)
+
+// selfCgroup lists the cgroups of the agent process.
+var selfCgroup = "/proc/self/cgroup"
+
+var (
+	errCgroupUnavailable = errors.New("cgroup v2 hierarchy not found")
+	errCgroupMode        = errors.New("unknown cgroup mode")
+)
+
+// cgroupFS reads the unified (v2) cgroup hierarchy mounted at root.
+type cgroupFS struct {
+	root string
+}
+
+// detectCgroup resolves the cgroup mode: "on" requires a v2 hierarchy at
+// root, "auto" uses it only when present and "off" never does. The result
+// points at the agent's own cgroup and is nil when host-wide values should
+// be reported, e.g. on a host where the agent runs in the root cgroup,
+// which has no memory accounting files.
+func detectCgroup(mode, root string) (*cgroupFS, error) {
+	switch mode {
+	case CgroupOff:
+		return nil, nil
+	case CgroupAuto, CgroupOn, "":
+	default:
+		return nil, fmt.Errorf("%w: %s", errCgroupMode, mode)
+	}
+
+	_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+	if err == nil {
+		dir := filepath.Join(root, ownCgroup())
+
+		_, err = os.Stat(filepath.Join(dir, "memory.current"))
+		if err == nil {
+			return &cgroupFS{root: dir}, nil
+		}
+	}
+
+	if mode == CgroupOn {
+		return nil, fmt.Errorf("%w at %s: %s", errCgroupUnavailable, root, err)
+	}
+
+	return nil, nil
+}
+
+// ownCgroup returns the v2 cgroup path of the agent from selfCgroup ("0::/path").
+// It is "/" when unknown, which is also what a cgroup namespace shows.
+func ownCgroup() string {
+	data, err := os.ReadFile(selfCgroup)
+	if err != nil {
+		return "/"
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		path := strings.TrimPrefix(line, "0::")
+		if path != line && strings.HasPrefix(path, "/") {
+			return path
+		}
+	}
+
+	return "/"
+}
+
+// readValue reads a single-value file. The second result is false when the
+// file holds "max", i.e. the resource is not limited.
+func (fs *cgroupFS) readValue(name string) (uint64, bool, error) {
+	data, err := os.ReadFile(filepath.Join(fs.root, name))
+	if err != nil {
+		return 0, false, err
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, false, nil
+	}
+
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return n, true, nil
+}
+
+// readKeyed reads flat keyed files like cpu.stat ("key value" per line) and
+// nested keyed files like io.stat ("8:0 rbytes=1 wbytes=2" per line),
+// summing values with the same key.
+func (fs *cgroupFS) readKeyed(name string) (map[string]uint64, error) {
+	file, err := os.Open(filepath.Join(fs.root, name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 2 && !strings.Contains(fields[1], "=") {
+			n, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+
+			values[fields[0]] += n
+
+			continue
+		}
+
+		for _, field := range fields {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+
+			values[key] += n
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// memory returns the limit and usage of the cgroup. ok is false when no
+// memory limit is set.
+func (fs *cgroupFS) memory() (limit, usage uint64, ok bool, err error) {
+	usage, _, err = fs.readValue("memory.current")
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	limit, ok, err = fs.readValue("memory.max")
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	return limit, usage, ok, nil
+}
+
+type Cgroup struct {
+	interval time.Duration
+	fs       *cgroupFS
+
+	mu    *sync.Mutex
+	stats *deltas
+}
+
+func NewCgroup(interval time.Duration, root string) *Cgroup {
+	return &Cgroup{
+		interval: interval,
+		fs:       &cgroupFS{root: root},
+
+		mu:    &sync.Mutex{},
+		stats: newDeltas(),
+	}
+}
+
+func (c *Cgroup) Name() string {
+	return "cgroup"
+}
+
+func (c *Cgroup) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *Cgroup) Collect(ctx context.Context) ([]types.ValueJSON, error) {
+	limit, usage, limited, err := c.fs.memory()
+	if err != nil {
+		return nil, err
+	}
+
+	values := []types.ValueJSON{Gauge("cgroup.memory.current", float64(usage))}
+
+	if limited {
+		values = append(values, Gauge("cgroup.memory.max", float64(limit)))
+	}
+
+	pids, _, err := c.fs.readValue("pids.current")
+	if err == nil {
+		values = append(values, Gauge("cgroup.pids.current", float64(pids)))
+	}
+
+	cpuStat, err := c.fs.readKeyed("cpu.stat")
+	if err != nil {
+		return nil, err
+	}
+
+	ioStat, err := c.fs.readKeyed("io.stat")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, total := range map[string]uint64{
+		"cgroup.cpu.usage_usec":     cpuStat["usage_usec"],
+		"cgroup.cpu.user_usec":      cpuStat["user_usec"],
+		"cgroup.cpu.system_usec":    cpuStat["system_usec"],
+		"cgroup.cpu.nr_periods":     cpuStat["nr_periods"],
+		"cgroup.cpu.nr_throttled":   cpuStat["nr_throttled"],
+		"cgroup.cpu.throttled_usec": cpuStat["throttled_usec"],
+		"cgroup.io.read_bytes":      ioStat["rbytes"],
+		"cgroup.io.write_bytes":     ioStat["wbytes"],
+		"cgroup.io.read_ops":        ioStat["rios"],
+		"cgroup.io.write_ops":       ioStat["wios"],
+	} {
+		if delta, ok := c.stats.observe(name, total); ok {
+			values = append(values, Counter(name, delta))
+		}
+	}
+
+	c.stats.forget()
+
+	return values, nil
+}
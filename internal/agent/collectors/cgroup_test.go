@@ -0,0 +1,215 @@
+package collectors
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/agent/config"
+	"github.com/ustkit/cmas/internal/types"
+)
+
+func writeCgroupTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0o600))
+	}
+
+	return root
+}
+
+// fakeSelfCgroup points selfCgroup at a file holding path as the agent's
+// v2 cgroup.
+func fakeSelfCgroup(t *testing.T, path string) {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "cgroup")
+	require.NoError(t, os.WriteFile(file, []byte("1:name=systemd:/\n0::"+path+"\n"), 0o600))
+
+	previous := selfCgroup
+	selfCgroup = file
+
+	t.Cleanup(func() { selfCgroup = previous })
+}
+
+func TestDetectCgroup(t *testing.T) {
+	fakeSelfCgroup(t, "/")
+
+	v2 := writeCgroupTree(t, map[string]string{"cgroup.controllers": "cpu io memory pids\n", "memory.current": "100\n"})
+	v1 := t.TempDir()
+	host := writeCgroupTree(t, map[string]string{"cgroup.controllers": "cpu io memory pids\n"})
+
+	tests := []struct {
+		name     string
+		mode     string
+		root     string
+		wantRoot string
+		wantErr  error
+	}{
+		{name: "case 1", mode: CgroupAuto, root: v2, wantRoot: v2},
+		{name: "case 2", mode: CgroupAuto, root: v1, wantRoot: ""},
+		{name: "case 3", mode: CgroupOn, root: v2, wantRoot: v2},
+		{name: "case 4", mode: CgroupOn, root: v1, wantErr: errCgroupUnavailable},
+		{name: "case 5", mode: CgroupOff, root: v2, wantRoot: ""},
+		{name: "case 6", mode: "maybe", root: v2, wantErr: errCgroupMode},
+		{name: "case 7", mode: CgroupAuto, root: host, wantRoot: ""},
+		{name: "case 8", mode: CgroupOn, root: host, wantErr: errCgroupUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, err := detectCgroup(tt.mode, tt.root)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			root := ""
+			if fs != nil {
+				root = fs.root
+			}
+
+			assert.Equal(t, tt.wantRoot, root)
+		})
+	}
+}
+
+func TestCgroup_Collect(t *testing.T) {
+	root := writeCgroupTree(t, map[string]string{
+		"cgroup.controllers": "cpu io memory pids\n",
+		"memory.current":     "104857600\n",
+		"memory.max":         "268435456\n",
+		"pids.current":       "12\n",
+		"cpu.stat":           "usage_usec 1000\nuser_usec 600\nsystem_usec 400\nnr_periods 10\nnr_throttled 1\nthrottled_usec 50\n",
+		"io.stat":            "8:0 rbytes=4096 wbytes=0 rios=1 wios=0 dbytes=0 dios=0\n8:16 rbytes=4096 wbytes=512 rios=1 wios=1 dbytes=0 dios=0\n",
+	})
+
+	collector := NewCgroup(0, root)
+
+	values, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	gauges := make(map[string]types.Gauge)
+	for _, v := range values {
+		require.Equal(t, GAUGE, v.MType)
+		gauges[v.ID] = *v.Value
+	}
+
+	assert.Equal(t, map[string]types.Gauge{
+		"cgroup.memory.current": 104857600,
+		"cgroup.memory.max":     268435456,
+		"cgroup.pids.current":   12,
+	}, gauges)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cpu.stat"),
+		[]byte("usage_usec 3000\nuser_usec 1600\nsystem_usec 1400\nnr_periods 20\nnr_throttled 4\nthrottled_usec 250\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "io.stat"),
+		[]byte("8:0 rbytes=8192 wbytes=0 rios=2 wios=0\n8:16 rbytes=4096 wbytes=1024 rios=1 wios=2\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "memory.max"), []byte("max\n"), 0o600))
+
+	values, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	deltas := make(map[string]types.Counter)
+	gauges = make(map[string]types.Gauge)
+
+	for _, v := range values {
+		switch v.MType {
+		case GAUGE:
+			gauges[v.ID] = *v.Value
+		case COUNTER:
+			deltas[v.ID] = *v.Delta
+		}
+	}
+
+	assert.NotContains(t, gauges, "cgroup.memory.max")
+	assert.Equal(t, map[string]types.Counter{
+		"cgroup.cpu.usage_usec":     2000,
+		"cgroup.cpu.user_usec":      1000,
+		"cgroup.cpu.system_usec":    1000,
+		"cgroup.cpu.nr_periods":     10,
+		"cgroup.cpu.nr_throttled":   3,
+		"cgroup.cpu.throttled_usec": 200,
+		"cgroup.io.read_bytes":      4096,
+		"cgroup.io.write_bytes":     512,
+		"cgroup.io.read_ops":        1,
+		"cgroup.io.write_ops":       1,
+	}, deltas)
+}
+
+func TestMemory_CollectCgroup(t *testing.T) {
+	root := writeCgroupTree(t, map[string]string{
+		"cgroup.controllers": "memory\n",
+		"memory.current":     "100\n",
+		"memory.max":         "250\n",
+	})
+
+	values, err := NewMemory(0, root).Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []types.ValueJSON{Gauge("TotalMemory", 250), Gauge("FreeMemory", 150)}, values)
+}
+
+func TestDetectCgroup_OwnCgroup(t *testing.T) {
+	root := writeCgroupTree(t, map[string]string{"cgroup.controllers": "cpu io memory pids\n"})
+	dir := filepath.Join(root, "system.slice", "agent.service")
+	require.NoError(t, os.MkdirAll(dir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "memory.current"), []byte("100\n"), 0o600))
+
+	fakeSelfCgroup(t, "/system.slice/agent.service")
+
+	fs, err := detectCgroup(CgroupAuto, root)
+	require.NoError(t, err)
+	require.NotNil(t, fs)
+	assert.Equal(t, dir, fs.root)
+}
+
+func TestMemory_CollectHostCgroup(t *testing.T) {
+	root := writeCgroupTree(t, map[string]string{"cgroup.controllers": "cpu io memory pids\n"})
+
+	values, err := NewMemory(0, root).Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, values, 2)
+	assert.Equal(t, "TotalMemory", values[0].ID)
+	assert.NotZero(t, *values[0].Value)
+}
+
+func TestNewDefaultRegistry_Cgroup(t *testing.T) {
+	fakeSelfCgroup(t, "/")
+
+	root := writeCgroupTree(t, map[string]string{"cgroup.controllers": "memory\n", "memory.current": "100\n"})
+
+	registry, err := NewDefaultRegistry(&config.Config{PollInterval: time.Second, Collectors: []string{"memory"}, Cgroup: CgroupAuto, CgroupRoot: root})
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, c := range registry.Enabled() {
+		names = append(names, c.Name())
+	}
+
+	assert.Equal(t, []string{"memory", "cgroup"}, names)
+}
+
+func TestNewDefaultRegistry_CgroupExplicit(t *testing.T) {
+	fakeSelfCgroup(t, "/")
+
+	host := writeCgroupTree(t, map[string]string{"cgroup.controllers": "memory\n"})
+
+	_, err := NewDefaultRegistry(&config.Config{PollInterval: time.Second, Collectors: []string{"cgroup"}, Cgroup: CgroupAuto, CgroupRoot: host})
+	assert.ErrorIs(t, err, errCgroupUnavailable)
+
+	// Without the cgroup collector the memory collector falls back to the
+	// host values.
+	registry, err := NewDefaultRegistry(&config.Config{PollInterval: time.Second, Collectors: []string{"memory"}, Cgroup: CgroupAuto, CgroupRoot: host})
+	require.NoError(t, err)
+	require.Len(t, registry.Enabled(), 1)
+}
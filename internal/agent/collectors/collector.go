@@ -0,0 +1,108 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ustkit/cmas/internal/types"
+)
+
+const (
+	GAUGE   = "gauge"
+	COUNTER = "counter"
+)
+
+var (
+	errDuplicateCollector = errors.New("collector already registered")
+	errUnknownCollector   = errors.New("unknown collector")
+)
+
+type Collector interface {
+	Name() string
+	Interval() time.Duration
+	Collect(ctx context.Context) ([]types.ValueJSON, error)
+}
+
+type Registry struct {
+	mu         *sync.RWMutex
+	collectors []Collector
+	enabled    map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{mu: &sync.RWMutex{}, enabled: make(map[string]bool)}
+}
+
+func (r *Registry) Register(c Collector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.enabled[c.Name()]; ok {
+		return fmt.Errorf("%w: %s", errDuplicateCollector, c.Name())
+	}
+
+	r.collectors = append(r.collectors, c)
+	r.enabled[c.Name()] = false
+
+	return nil
+}
+
+func (r *Registry) Enable(names ...string) error {
+	return r.setEnabled(true, names)
+}
+
+func (r *Registry) Disable(names ...string) error {
+	return r.setEnabled(false, names)
+}
+
+func (r *Registry) setEnabled(enabled bool, names []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if _, ok := r.enabled[name]; !ok {
+			return fmt.Errorf("%w: %s", errUnknownCollector, name)
+		}
+
+		r.enabled[name] = enabled
+	}
+
+	return nil
+}
+
+func (r *Registry) Enabled() []Collector {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	collectors := make([]Collector, 0, len(r.collectors))
+
+	for _, c := range r.collectors {
+		if r.enabled[c.Name()] {
+			collectors = append(collectors, c)
+		}
+	}
+
+	return collectors
+}
+
+// Gauge and Counter build the values collectors report.
+func Gauge(name string, value float64) types.ValueJSON {
+	g := types.Gauge(value)
+
+	return types.ValueJSON{ID: name, MType: GAUGE, Value: &g}
+}
+
+func Counter(name string, delta int64) types.ValueJSON {
+	c := types.Counter(delta)
+
+	return types.ValueJSON{ID: name, MType: COUNTER, Delta: &c}
+}
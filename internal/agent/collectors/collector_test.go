@@ -0,0 +1,98 @@
+package collectors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/agent/config"
+	"github.com/ustkit/cmas/internal/types"
+)
+
+type fakeCollector struct {
+	name string
+}
+
+func (c fakeCollector) Name() string {
+	return c.name
+}
+
+func (c fakeCollector) Interval() time.Duration {
+	return time.Second
+}
+
+func (c fakeCollector) Collect(ctx context.Context) ([]types.ValueJSON, error) {
+	return []types.ValueJSON{Gauge(c.name, 1)}, nil
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	require.NoError(t, registry.Register(fakeCollector{name: "first"}))
+	require.NoError(t, registry.Register(fakeCollector{name: "second"}))
+
+	err := registry.Register(fakeCollector{name: "first"})
+	assert.ErrorIs(t, err, errDuplicateCollector)
+
+	assert.Empty(t, registry.Enabled())
+
+	require.NoError(t, registry.Enable("second", "first"))
+	assert.Equal(t, []Collector{fakeCollector{name: "first"}, fakeCollector{name: "second"}}, registry.Enabled())
+
+	require.NoError(t, registry.Disable("first"))
+	assert.Equal(t, []Collector{fakeCollector{name: "second"}}, registry.Enabled())
+
+	err = registry.Enable("unknown")
+	assert.ErrorIs(t, err, errUnknownCollector)
+}
+
+func TestNewDefaultRegistry(t *testing.T) {
+	tests := []struct {
+		name       string
+		collectors []string
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "case 1",
+			collectors: []string{"runtime", "memory", "cpu"},
+			want:       []string{"runtime", "memory", "cpu"},
+		},
+		{
+			name:       "case 2",
+			collectors: []string{"runtime"},
+			want:       []string{"runtime"},
+		},
+		{
+			name:       "case 3",
+			collectors: nil,
+			want:       []string{},
+		},
+		{
+			name:       "case 4",
+			collectors: []string{"runtime", "unknown"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry, err := NewDefaultRegistry(&config.Config{PollInterval: time.Second, Collectors: tt.collectors, Cgroup: CgroupOff})
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			names := []string{}
+			for _, c := range registry.Enabled() {
+				names = append(names, c.Name())
+			}
+
+			assert.Equal(t, tt.want, names)
+		})
+	}
+}
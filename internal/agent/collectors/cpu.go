@@ -0,0 +1,104 @@
+package collectors
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+
+	"github.com/ustkit/cmas/internal/types"
+)
+
+type cpuTimesFunc func(ctx context.Context, percpu bool) ([]cpu.TimesStat, error)
+
+// CPU reports utilization computed from the difference between two consecutive
+// cpu.Times snapshots, so Collect never sleeps. The first call only records a
+// baseline and returns no values.
+type CPU struct {
+	interval time.Duration
+	times    cpuTimesFunc
+
+	mu        *sync.Mutex
+	lastTotal *cpu.TimesStat
+	lastCores []cpu.TimesStat
+}
+
+func NewCPU(interval time.Duration) *CPU {
+	return &CPU{interval: interval, times: cpu.TimesWithContext, mu: &sync.Mutex{}}
+}
+
+func (c *CPU) Name() string {
+	return "cpu"
+}
+
+func (c *CPU) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *CPU) Collect(ctx context.Context) ([]types.ValueJSON, error) {
+	cores, err := c.times(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := c.times(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := make([]types.ValueJSON, 0, len(cores)+5)
+
+	if len(c.lastCores) == len(cores) {
+		for i := range cores {
+			values = append(values, Gauge("CPUutilization"+strconv.Itoa(i+1), cpuBusyPercent(c.lastCores[i], cores[i])))
+		}
+	}
+
+	if c.lastTotal != nil && len(total) > 0 {
+		prev, cur := *c.lastTotal, total[0]
+		elapsed := cpuTotal(cur) - cpuTotal(prev)
+
+		if elapsed > 0 {
+			values = append(values,
+				Gauge("CPUuser", 100*(cur.User-prev.User)/elapsed),
+				Gauge("CPUsystem", 100*(cur.System-prev.System)/elapsed),
+				Gauge("CPUiowait", 100*(cur.Iowait-prev.Iowait)/elapsed),
+				Gauge("CPUsteal", 100*(cur.Steal-prev.Steal)/elapsed),
+				Gauge("CPUidle", 100*(cur.Idle-prev.Idle)/elapsed),
+			)
+		}
+	}
+
+	c.lastCores = cores
+
+	if len(total) > 0 {
+		c.lastTotal = &total[0]
+	}
+
+	return values, nil
+}
+
+func cpuTotal(t cpu.TimesStat) float64 {
+	return t.User + t.System + t.Idle + t.Nice + t.Iowait + t.Irq + t.Softirq + t.Steal
+}
+
+func cpuBusyPercent(prev, cur cpu.TimesStat) float64 {
+	elapsed := cpuTotal(cur) - cpuTotal(prev)
+	if elapsed <= 0 {
+		return 0
+	}
+
+	idle := (cur.Idle + cur.Iowait) - (prev.Idle + prev.Iowait)
+
+	busy := 100 * (elapsed - idle) / elapsed
+	if busy < 0 {
+		return 0
+	}
+
+	return busy
+}
@@ -0,0 +1,66 @@
+package collectors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/types"
+)
+
+func TestCPU_Collect(t *testing.T) {
+	snapshots := [][]cpu.TimesStat{
+		{
+			{CPU: "cpu0", User: 10, System: 10, Idle: 80},
+			{CPU: "cpu1", User: 0, System: 0, Idle: 100},
+		},
+		{
+			{CPU: "cpu0", User: 60, System: 10, Idle: 130},
+			{CPU: "cpu1", User: 0, System: 25, Idle: 175},
+		},
+	}
+	step := 0
+
+	collector := NewCPU(0)
+	collector.times = func(ctx context.Context, percpu bool) ([]cpu.TimesStat, error) {
+		cores := snapshots[step]
+		if percpu {
+			return cores, nil
+		}
+
+		total := cpu.TimesStat{CPU: "cpu-total"}
+		for _, core := range cores {
+			total.User += core.User
+			total.System += core.System
+			total.Idle += core.Idle
+		}
+
+		return []cpu.TimesStat{total}, nil
+	}
+
+	values, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, values)
+
+	step++
+
+	values, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	got := make(map[string]types.Gauge)
+	for _, v := range values {
+		got[v.ID] = *v.Value
+	}
+
+	assert.Equal(t, map[string]types.Gauge{
+		"CPUutilization1": 50,
+		"CPUutilization2": 25,
+		"CPUuser":         25,
+		"CPUsystem":       12.5,
+		"CPUiowait":       0,
+		"CPUsteal":        0,
+		"CPUidle":         62.5,
+	}, got)
+}
@@ -0,0 +1,84 @@
+package collectors
+
+import (
+	"strings"
+
+	"github.com/ustkit/cmas/internal/agent/config"
+)
+
+func NewDefaultRegistry(agentConfig *config.Config) (*Registry, error) {
+	pollInterval := agentConfig.PollInterval
+
+	processes, err := NewProcessMatchers(agentConfig.Processes)
+	if err != nil {
+		return nil, err
+	}
+
+	cgroup, err := detectCgroup(agentConfig.Cgroup, agentConfig.CgroupRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	cgroupRoot, cgroupDir := "", ""
+	if cgroup != nil {
+		cgroupRoot, cgroupDir = cgroup.root, cgroup.root
+	}
+
+	// An explicitly enabled cgroup collector has to work whatever the mode,
+	// rather than failing on every poll.
+	if cgroup == nil && listed(agentConfig.Collectors, "cgroup") {
+		explicit, err := detectCgroup(CgroupOn, agentConfig.CgroupRoot)
+		if err != nil {
+			return nil, err
+		}
+
+		cgroupDir = explicit.root
+	}
+
+	registry := NewRegistry()
+
+	for _, c := range []Collector{
+		NewRuntime(pollInterval),
+		NewRuntimeMetrics(pollInterval),
+		NewMemory(pollInterval, cgroupRoot),
+		NewCPU(pollInterval),
+		NewDisk(pollInterval,
+			Filter{Include: agentConfig.Disk.Mountpoints, Exclude: agentConfig.Disk.MountpointsExclude},
+			Filter{Include: agentConfig.Disk.Fstypes, Exclude: agentConfig.Disk.FstypesExclude}),
+		NewNetwork(pollInterval, Filter{Include: agentConfig.Net.Interfaces, Exclude: agentConfig.Net.InterfacesExclude}),
+		NewHost(pollInterval),
+		NewProcess(pollInterval, processes),
+		NewCgroup(pollInterval, cgroupDir),
+		NewPressure(pollInterval, agentConfig.PressureRoot),
+		NewStatsD(agentConfig.ReportInterval, agentConfig.StatsD.Address, agentConfig.StatsD.Socket),
+	} {
+		err := registry.Register(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = registry.Enable(agentConfig.Collectors...)
+	if err != nil {
+		return nil, err
+	}
+
+	if cgroupRoot != "" {
+		err = registry.Enable("cgroup")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
+
+func listed(names []string, name string) bool {
+	for _, n := range names {
+		if strings.TrimSpace(n) == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,41 @@
+package collectors
+
+// deltas turns monotonically growing totals into increments between two
+// consecutive observations. The first observation of a key only records a
+// baseline; a total that went backwards is treated as a reset.
+type deltas struct {
+	last map[string]uint64
+	seen map[string]bool
+}
+
+func newDeltas() *deltas {
+	return &deltas{last: make(map[string]uint64), seen: make(map[string]bool)}
+}
+
+func (d *deltas) observe(key string, total uint64) (delta int64, ok bool) {
+	last, known := d.last[key]
+	d.last[key] = total
+	d.seen[key] = true
+
+	if !known {
+		return 0, false
+	}
+
+	if total < last {
+		return int64(total), true
+	}
+
+	return int64(total - last), true
+}
+
+// forget drops the keys that were not observed since the previous call, so
+// vanished devices do not keep stale baselines forever.
+func (d *deltas) forget() {
+	for key := range d.last {
+		if !d.seen[key] {
+			delete(d.last, key)
+		}
+	}
+
+	d.seen = make(map[string]bool)
+}
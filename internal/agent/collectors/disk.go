@@ -0,0 +1,138 @@
+package collectors
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+
+	"github.com/ustkit/cmas/internal/types"
+)
+
+type Disk struct {
+	interval    time.Duration
+	mountpoints Filter
+	fstypes     Filter
+
+	partitions func(ctx context.Context, all bool) ([]disk.PartitionStat, error)
+	usage      func(ctx context.Context, path string) (*disk.UsageStat, error)
+	ioCounters func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error)
+	resolve    func(path string) (string, error)
+
+	mu *sync.Mutex
+	io *deltas
+}
+
+func NewDisk(interval time.Duration, mountpoints, fstypes Filter) *Disk {
+	return &Disk{
+		interval:    interval,
+		mountpoints: mountpoints,
+		fstypes:     fstypes,
+
+		partitions: disk.PartitionsWithContext,
+		usage:      disk.UsageWithContext,
+		ioCounters: disk.IOCountersWithContext,
+		resolve:    filepath.EvalSymlinks,
+
+		mu: &sync.Mutex{},
+		io: newDeltas(),
+	}
+}
+
+func (c *Disk) Name() string {
+	return "disk"
+}
+
+func (c *Disk) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *Disk) Collect(ctx context.Context) ([]types.ValueJSON, error) {
+	partitions, err := c.partitions(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []types.ValueJSON{}
+	devices := []string{}
+
+	for _, partition := range partitions {
+		if !c.mountpoints.Match(partition.Mountpoint) || !c.fstypes.Match(partition.Fstype) {
+			continue
+		}
+
+		// Mountpoints that cannot be stat'ed (e.g. hidden by a container
+		// mount namespace) are skipped rather than failing the whole poll.
+		usage, err := c.usage(ctx, partition.Mountpoint)
+		if err != nil {
+			continue
+		}
+
+		prefix := "disk." + mountpointName(partition.Mountpoint)
+		values = append(values,
+			Gauge(prefix+".total", float64(usage.Total)),
+			Gauge(prefix+".free", float64(usage.Free)),
+			Gauge(prefix+".used_percent", usage.UsedPercent),
+		)
+
+		devices = append(devices, c.deviceName(partition.Device))
+	}
+
+	if len(devices) == 0 {
+		return values, nil
+	}
+
+	// Usage is still worth reporting when the I/O counters are unavailable.
+	ioCounters, err := c.ioCounters(ctx, devices...)
+	if err != nil {
+		log.Printf("disk collector: io counters: %s", err)
+
+		return values, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, io := range ioCounters {
+		prefix := "diskio." + name
+
+		for suffix, total := range map[string]uint64{
+			".read_bytes":  io.ReadBytes,
+			".write_bytes": io.WriteBytes,
+			".read_count":  io.ReadCount,
+			".write_count": io.WriteCount,
+		} {
+			if delta, ok := c.io.observe(prefix+suffix, total); ok {
+				values = append(values, Counter(prefix+suffix, delta))
+			}
+		}
+	}
+
+	c.io.forget()
+
+	return values, nil
+}
+
+// deviceName returns the kernel name the I/O counters use for device:
+// /dev/mapper/* (LVM, LUKS) are symlinks to /dev/dm-N.
+func (c *Disk) deviceName(device string) string {
+	path, err := c.resolve(device)
+	if err != nil {
+		path = device
+	}
+
+	return filepath.Base(path)
+}
+
+func mountpointName(mountpoint string) string {
+	name := strings.Trim(mountpoint, "/")
+	if name == "" {
+		return "root"
+	}
+
+	return strings.ReplaceAll(name, "/", "_")
+}
@@ -0,0 +1,99 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/types"
+)
+
+func TestDisk_Collect(t *testing.T) {
+	reads := uint64(1000)
+
+	collector := NewDisk(0, Filter{Exclude: []string{"/boot"}}, Filter{Exclude: []string{"tmpfs"}})
+	collector.partitions = func(ctx context.Context, all bool) ([]disk.PartitionStat, error) {
+		return []disk.PartitionStat{
+			{Device: "/dev/sda1", Mountpoint: "/", Fstype: "ext4"},
+			{Device: "/dev/sda2", Mountpoint: "/boot", Fstype: "ext4"},
+			{Device: "/dev/sdb1", Mountpoint: "/var/lib", Fstype: "xfs"},
+			{Device: "tmpfs", Mountpoint: "/run", Fstype: "tmpfs"},
+		}, nil
+	}
+	collector.usage = func(ctx context.Context, path string) (*disk.UsageStat, error) {
+		return &disk.UsageStat{Path: path, Total: 100, Free: 40, UsedPercent: 60}, nil
+	}
+	collector.ioCounters = func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error) {
+		assert.Equal(t, []string{"sda1", "sdb1"}, names)
+
+		return map[string]disk.IOCountersStat{
+			"sda1": {Name: "sda1", ReadBytes: reads, WriteBytes: 10, ReadCount: 1, WriteCount: 1},
+		}, nil
+	}
+
+	gauges, deltas := collectMap(t, collector)
+	assert.Empty(t, deltas)
+	assert.Equal(t, map[string]types.Gauge{
+		"disk.root.total":           100,
+		"disk.root.free":            40,
+		"disk.root.used_percent":    60,
+		"disk.var_lib.total":        100,
+		"disk.var_lib.free":         40,
+		"disk.var_lib.used_percent": 60,
+	}, gauges)
+
+	reads += 4096
+
+	_, deltas = collectMap(t, collector)
+	assert.Equal(t, map[string]types.Counter{
+		"diskio.sda1.read_bytes":  4096,
+		"diskio.sda1.write_bytes": 0,
+		"diskio.sda1.read_count":  0,
+		"diskio.sda1.write_count": 0,
+	}, deltas)
+}
+
+func TestDisk_CollectMapperAndIOError(t *testing.T) {
+	collector := NewDisk(0, Filter{}, Filter{})
+	collector.partitions = func(ctx context.Context, all bool) ([]disk.PartitionStat, error) {
+		return []disk.PartitionStat{
+			{Device: "/dev/mapper/vg-root", Mountpoint: "/", Fstype: "ext4"},
+			{Device: "/dev/sda1", Mountpoint: "/boot", Fstype: "ext4"},
+		}, nil
+	}
+	collector.usage = func(ctx context.Context, path string) (*disk.UsageStat, error) {
+		return &disk.UsageStat{Path: path, Total: 100, Free: 40, UsedPercent: 60}, nil
+	}
+	collector.resolve = func(path string) (string, error) {
+		if path == "/dev/mapper/vg-root" {
+			return "/dev/dm-0", nil
+		}
+
+		return "", os.ErrNotExist
+	}
+
+	fail := true
+	collector.ioCounters = func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error) {
+		assert.Equal(t, []string{"dm-0", "sda1"}, names)
+
+		if fail {
+			return nil, errors.New("no diskstats")
+		}
+
+		return map[string]disk.IOCountersStat{"dm-0": {Name: "dm-0", ReadBytes: 1}}, nil
+	}
+
+	values, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, values, 6)
+
+	fail = false
+
+	values, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, values, 6)
+}
@@ -0,0 +1,30 @@
+package collectors
+
+import "path/filepath"
+
+// Filter selects names by glob patterns. An empty include list matches
+// everything; exclude patterns always win.
+type Filter struct {
+	Include []string
+	Exclude []string
+}
+
+func (f Filter) Match(name string) bool {
+	for _, pattern := range f.Exclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return false
+		}
+	}
+
+	if len(f.Include) == 0 {
+		return true
+	}
+
+	for _, pattern := range f.Include {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
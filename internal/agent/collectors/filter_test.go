@@ -0,0 +1,31 @@
+package collectors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter_Match(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		value   string
+		want    bool
+	}{
+		{name: "case 1", include: nil, exclude: nil, value: "/", want: true},
+		{name: "case 2", include: []string{"/", "/home"}, exclude: nil, value: "/home", want: true},
+		{name: "case 3", include: []string{"/", "/home"}, exclude: nil, value: "/var", want: false},
+		{name: "case 4", include: nil, exclude: []string{"/snap/*"}, value: "/snap/core", want: false},
+		{name: "case 5", include: []string{"/snap/*"}, exclude: []string{"/snap/core"}, value: "/snap/core", want: false},
+		{name: "case 6", include: nil, exclude: []string{"lo", "veth*"}, value: "veth0a1b", want: false},
+		{name: "case 7", include: nil, exclude: []string{"lo", "veth*"}, value: "eth0", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Filter{Include: tt.include, Exclude: tt.exclude}.Match(tt.value))
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+
+	"github.com/ustkit/cmas/internal/types"
+)
+
+type Host struct {
+	interval time.Duration
+	fileNr   string
+
+	avg      func(ctx context.Context) (*load.AvgStat, error)
+	misc     func(ctx context.Context) (*load.MiscStat, error)
+	uptime   func(ctx context.Context) (uint64, error)
+	bootTime func(ctx context.Context) (uint64, error)
+	swap     func(ctx context.Context) (*mem.SwapMemoryStat, error)
+}
+
+func NewHost(interval time.Duration) *Host {
+	return &Host{
+		interval: interval,
+		fileNr:   "/proc/sys/fs/file-nr",
+
+		avg:      load.AvgWithContext,
+		misc:     load.MiscWithContext,
+		uptime:   host.UptimeWithContext,
+		bootTime: host.BootTimeWithContext,
+		swap:     mem.SwapMemoryWithContext,
+	}
+}
+
+func (c *Host) Name() string {
+	return "host"
+}
+
+func (c *Host) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *Host) Collect(ctx context.Context) ([]types.ValueJSON, error) {
+	avg, err := c.avg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	misc, err := c.misc(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	uptime, err := c.uptime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bootTime, err := c.bootTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	swap, err := c.swap(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []types.ValueJSON{
+		Gauge("host.load1", avg.Load1),
+		Gauge("host.load5", avg.Load5),
+		Gauge("host.load15", avg.Load15),
+		Gauge("host.uptime", float64(uptime)),
+		Gauge("host.boot_time", float64(bootTime)),
+		Gauge("host.swap_total", float64(swap.Total)),
+		Gauge("host.swap_used", float64(swap.Used)),
+		Gauge("host.swap_free", float64(swap.Free)),
+		Gauge("host.procs_running", float64(misc.ProcsRunning)),
+		Gauge("host.procs_blocked", float64(misc.ProcsBlocked)),
+		Gauge("host.procs_total", float64(misc.ProcsTotal)),
+	}
+
+	openFDs, err := readOpenFDs(c.fileNr)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if err == nil {
+		values = append(values, Gauge("host.open_fds", float64(openFDs)))
+	}
+
+	return values, nil
+}
+
+// readOpenFDs returns the number of allocated file handles from
+// /proc/sys/fs/file-nr ("allocated unused max").
+func readOpenFDs(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, errors.New("file-nr: unexpected format")
+	}
+
+	return strconv.ParseUint(fields[0], 10, 64)
+}
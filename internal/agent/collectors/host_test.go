@@ -0,0 +1,66 @@
+package collectors
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/types"
+)
+
+func TestHost_Collect(t *testing.T) {
+	fileNr := filepath.Join(t.TempDir(), "file-nr")
+	require.NoError(t, os.WriteFile(fileNr, []byte("1504\t0\t9223372036854775807\n"), 0o600))
+
+	collector := NewHost(0)
+	collector.fileNr = fileNr
+	collector.avg = func(ctx context.Context) (*load.AvgStat, error) {
+		return &load.AvgStat{Load1: 0.5, Load5: 0.25, Load15: 0.125}, nil
+	}
+	collector.misc = func(ctx context.Context) (*load.MiscStat, error) {
+		return &load.MiscStat{ProcsRunning: 2, ProcsBlocked: 1, ProcsTotal: 300}, nil
+	}
+	collector.uptime = func(ctx context.Context) (uint64, error) {
+		return 3600, nil
+	}
+	collector.bootTime = func(ctx context.Context) (uint64, error) {
+		return 1650000000, nil
+	}
+	collector.swap = func(ctx context.Context) (*mem.SwapMemoryStat, error) {
+		return &mem.SwapMemoryStat{Total: 1024, Used: 256, Free: 768}, nil
+	}
+
+	values, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	got := make(map[string]types.Gauge)
+	for _, v := range values {
+		got[v.ID] = *v.Value
+	}
+
+	assert.Equal(t, map[string]types.Gauge{
+		"host.load1":         0.5,
+		"host.load5":         0.25,
+		"host.load15":        0.125,
+		"host.uptime":        3600,
+		"host.boot_time":     1650000000,
+		"host.swap_total":    1024,
+		"host.swap_used":     256,
+		"host.swap_free":     768,
+		"host.procs_running": 2,
+		"host.procs_blocked": 1,
+		"host.procs_total":   300,
+		"host.open_fds":      1504,
+	}, got)
+
+	collector.fileNr = filepath.Join(t.TempDir(), "missing")
+
+	values, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, values, 11)
+}
@@ -0,0 +1,70 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/mem"
+
+	"github.com/ustkit/cmas/internal/types"
+)
+
+// Memory reports total and free memory. When cgroupRoot is set and the
+// cgroup has a memory limit, the limit and the room left under it are
+// reported instead of the host values, which are also used when the cgroup
+// has no memory accounting files.
+type Memory struct {
+	interval time.Duration
+	cgroup   *cgroupFS
+}
+
+func NewMemory(interval time.Duration, cgroupRoot string) *Memory {
+	memory := &Memory{interval: interval}
+
+	if cgroupRoot != "" {
+		memory.cgroup = &cgroupFS{root: cgroupRoot}
+	}
+
+	return memory
+}
+
+func (c *Memory) Name() string {
+	return "memory"
+}
+
+func (c *Memory) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *Memory) Collect(ctx context.Context) ([]types.ValueJSON, error) {
+	if c.cgroup != nil {
+		limit, usage, limited, err := c.cgroup.memory()
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		if limited {
+			free := uint64(0)
+			if limit > usage {
+				free = limit - usage
+			}
+
+			return []types.ValueJSON{
+				Gauge("TotalMemory", float64(limit)),
+				Gauge("FreeMemory", float64(free)),
+			}, nil
+		}
+	}
+
+	virtMem, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return []types.ValueJSON{
+		Gauge("TotalMemory", float64(virtMem.Total)),
+		Gauge("FreeMemory", float64(virtMem.Free)),
+	}, nil
+}
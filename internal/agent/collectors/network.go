@@ -0,0 +1,102 @@
+package collectors
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+
+	"github.com/ustkit/cmas/internal/types"
+)
+
+var tcpStates = []string{
+	"ESTABLISHED", "SYN_SENT", "SYN_RECV", "FIN_WAIT1", "FIN_WAIT2", "TIME_WAIT",
+	"CLOSE", "CLOSE_WAIT", "LAST_ACK", "LISTEN", "CLOSING",
+}
+
+type Network struct {
+	interval   time.Duration
+	interfaces Filter
+
+	ioCounters  func(ctx context.Context, pernic bool) ([]net.IOCountersStat, error)
+	connections func(ctx context.Context, kind string) ([]net.ConnectionStat, error)
+
+	mu *sync.Mutex
+	io *deltas
+}
+
+func NewNetwork(interval time.Duration, interfaces Filter) *Network {
+	return &Network{
+		interval:   interval,
+		interfaces: interfaces,
+
+		ioCounters:  net.IOCountersWithContext,
+		connections: net.ConnectionsWithoutUidsWithContext,
+
+		mu: &sync.Mutex{},
+		io: newDeltas(),
+	}
+}
+
+func (c *Network) Name() string {
+	return "network"
+}
+
+func (c *Network) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *Network) Collect(ctx context.Context) ([]types.ValueJSON, error) {
+	ioCounters, err := c.ioCounters(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	connections, err := c.connections(ctx, "tcp")
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]types.ValueJSON, 0, len(ioCounters)*8+len(tcpStates))
+
+	c.mu.Lock()
+
+	for _, io := range ioCounters {
+		if !c.interfaces.Match(io.Name) {
+			continue
+		}
+
+		prefix := "net." + io.Name
+
+		for suffix, total := range map[string]uint64{
+			".bytes_sent":   io.BytesSent,
+			".bytes_recv":   io.BytesRecv,
+			".packets_sent": io.PacketsSent,
+			".packets_recv": io.PacketsRecv,
+			".errors_in":    io.Errin,
+			".errors_out":   io.Errout,
+			".drops_in":     io.Dropin,
+			".drops_out":    io.Dropout,
+		} {
+			if delta, ok := c.io.observe(prefix+suffix, total); ok {
+				values = append(values, Counter(prefix+suffix, delta))
+			}
+		}
+	}
+
+	c.io.forget()
+	c.mu.Unlock()
+
+	states := make(map[string]int, len(tcpStates))
+	for _, conn := range connections {
+		states[conn.Status]++
+	}
+
+	for _, state := range tcpStates {
+		values = append(values, Gauge("net.tcp."+strings.ToLower(state), float64(states[state])))
+	}
+
+	return values, nil
+}
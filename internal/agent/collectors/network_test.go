@@ -0,0 +1,52 @@
+package collectors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/ustkit/cmas/internal/types"
+)
+
+func TestNetwork_Collect(t *testing.T) {
+	sent := uint64(500)
+
+	collector := NewNetwork(0, Filter{Exclude: []string{"lo", "veth*"}})
+	collector.ioCounters = func(ctx context.Context, pernic bool) ([]net.IOCountersStat, error) {
+		return []net.IOCountersStat{
+			{Name: "lo", BytesSent: sent, BytesRecv: sent},
+			{Name: "veth12ab", BytesSent: sent},
+			{Name: "eth0", BytesSent: sent, BytesRecv: 100, PacketsSent: 5, Dropin: 1},
+		}, nil
+	}
+	collector.connections = func(ctx context.Context, kind string) ([]net.ConnectionStat, error) {
+		return []net.ConnectionStat{
+			{Status: "LISTEN"},
+			{Status: "ESTABLISHED"},
+			{Status: "ESTABLISHED"},
+		}, nil
+	}
+
+	_, deltas := collectMap(t, collector)
+	assert.Empty(t, deltas)
+
+	sent += 250
+
+	gauges, deltas := collectMap(t, collector)
+
+	assert.Equal(t, map[string]types.Counter{
+		"net.eth0.bytes_sent":   250,
+		"net.eth0.bytes_recv":   0,
+		"net.eth0.packets_sent": 0,
+		"net.eth0.packets_recv": 0,
+		"net.eth0.errors_in":    0,
+		"net.eth0.errors_out":   0,
+		"net.eth0.drops_in":     0,
+		"net.eth0.drops_out":    0,
+	}, deltas)
+	assert.Equal(t, types.Gauge(2), gauges["net.tcp.established"])
+	assert.Equal(t, types.Gauge(1), gauges["net.tcp.listen"])
+	assert.Equal(t, types.Gauge(0), gauges["net.tcp.time_wait"])
+	assert.Len(t, gauges, len(tcpStates))
+}
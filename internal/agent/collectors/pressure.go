@@ -0,0 +1,154 @@
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ustkit/cmas/internal/types"
+)
+
+var pressureResources = []string{"cpu", "memory", "io"}
+
+// Pressure reports Linux pressure stall information. Resources the kernel
+// does not expose are skipped; the first failure for each one is logged.
+type Pressure struct {
+	interval time.Duration
+	root     string
+
+	mu          *sync.Mutex
+	totals      *deltas
+	unavailable map[string]bool
+}
+
+func NewPressure(interval time.Duration, root string) *Pressure {
+	return &Pressure{
+		interval: interval,
+		root:     root,
+
+		mu:          &sync.Mutex{},
+		totals:      newDeltas(),
+		unavailable: make(map[string]bool),
+	}
+}
+
+func (c *Pressure) Name() string {
+	return "pressure"
+}
+
+func (c *Pressure) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *Pressure) Collect(ctx context.Context) ([]types.ValueJSON, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := []types.ValueJSON{}
+
+	for _, resource := range pressureResources {
+		if c.unavailable[resource] {
+			continue
+		}
+
+		lines, err := readPressure(filepath.Join(c.root, resource))
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.EOPNOTSUPP) {
+			log.Printf("pressure collector: %s pressure is not available, skipping: %s", resource, err)
+			c.unavailable[resource] = true
+
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for kind, line := range lines {
+			prefix := "psi." + resource + "." + kind
+
+			values = append(values,
+				Gauge(prefix+".avg10", line.avg10),
+				Gauge(prefix+".avg60", line.avg60),
+				Gauge(prefix+".avg300", line.avg300),
+			)
+
+			if delta, ok := c.totals.observe(prefix+".total", line.total); ok {
+				values = append(values, Counter(prefix+".total", delta))
+			}
+		}
+	}
+
+	c.totals.forget()
+
+	return values, nil
+}
+
+type pressureLine struct {
+	avg10, avg60, avg300 float64
+	total                uint64
+}
+
+// readPressure parses a /proc/pressure file:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func readPressure(path string) (map[string]pressureLine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := make(map[string]pressureLine)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		line := pressureLine{}
+
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s: unexpected field %q", path, field)
+			}
+
+			if key == "total" {
+				line.total, err = strconv.ParseUint(value, 10, 64)
+			} else {
+				var avg float64
+
+				avg, err = strconv.ParseFloat(value, 64)
+
+				switch key {
+				case "avg10":
+					line.avg10 = avg
+				case "avg60":
+					line.avg60 = avg
+				case "avg300":
+					line.avg300 = avg
+				}
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+
+		lines[fields[0]] = line
+	}
+
+	return lines, scanner.Err()
+}
@@ -0,0 +1,61 @@
+package collectors
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/types"
+)
+
+func TestPressure_Collect(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cpu"),
+		[]byte("some avg10=1.50 avg60=0.75 avg300=0.25 total=1000\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "memory"),
+		[]byte("some avg10=0.00 avg60=0.00 avg300=0.00 total=10\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=5\n"), 0o600))
+
+	collector := NewPressure(0, root)
+
+	values, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, values, 9)
+	assert.True(t, collector.unavailable["io"])
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cpu"),
+		[]byte("some avg10=2.00 avg60=1.00 avg300=0.50 total=1600\n"), 0o600))
+
+	values, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	gauges := make(map[string]types.Gauge)
+	deltas := make(map[string]types.Counter)
+
+	for _, v := range values {
+		switch v.MType {
+		case GAUGE:
+			gauges[v.ID] = *v.Value
+		case COUNTER:
+			deltas[v.ID] = *v.Delta
+		}
+	}
+
+	assert.Equal(t, types.Gauge(2), gauges["psi.cpu.some.avg10"])
+	assert.Equal(t, types.Gauge(0.5), gauges["psi.cpu.some.avg300"])
+	assert.Equal(t, map[string]types.Counter{
+		"psi.cpu.some.total":    600,
+		"psi.memory.some.total": 0,
+		"psi.memory.full.total": 0,
+	}, deltas)
+}
+
+func TestReadPressure_Malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cpu")
+	require.NoError(t, os.WriteFile(path, []byte("some avg10=abc\n"), 0o600))
+
+	_, err := readPressure(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,233 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+
+	"github.com/ustkit/cmas/internal/agent/config"
+	"github.com/ustkit/cmas/internal/types"
+)
+
+var errProcessRule = errors.New("invalid process rule")
+
+// ProcessMatcher selects the processes reported under one alias. Exactly one
+// of Name, Cmdline or Pidfile is set.
+type ProcessMatcher struct {
+	Alias   string
+	Name    string
+	Cmdline *regexp.Regexp
+	Pidfile string
+}
+
+// NewProcessMatchers compiles the process rules of the agent config.
+func NewProcessMatchers(processes []config.Process) ([]ProcessMatcher, error) {
+	matchers := make([]ProcessMatcher, 0, len(processes))
+
+	for _, p := range processes {
+		matcher := ProcessMatcher{Alias: p.Alias, Name: p.Name, Pidfile: p.Pidfile}
+
+		if p.Cmdline != "" {
+			re, err := regexp.Compile(p.Cmdline)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %s", errProcessRule, p.Alias, err)
+			}
+
+			matcher.Cmdline = re
+		}
+
+		matchers = append(matchers, matcher)
+	}
+
+	return matchers, nil
+}
+
+// Process reports resource usage of the processes selected by matchers,
+// summed per alias. PIDs are resolved again on every poll, so restarted
+// services are picked up automatically.
+type Process struct {
+	interval time.Duration
+	matchers []ProcessMatcher
+
+	mu    *sync.Mutex
+	procs map[string]*process.Process
+	io    *deltas
+}
+
+func NewProcess(interval time.Duration, matchers []ProcessMatcher) *Process {
+	return &Process{
+		interval: interval,
+		matchers: matchers,
+
+		mu:    &sync.Mutex{},
+		procs: make(map[string]*process.Process),
+		io:    newDeltas(),
+	}
+}
+
+func (c *Process) Name() string {
+	return "process"
+}
+
+func (c *Process) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *Process) Collect(ctx context.Context) ([]types.ValueJSON, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var all []*process.Process
+
+	// Process handles are kept per alias, PID and start time: gopsutil
+	// computes CPU percent from the previous call on the same handle, and a
+	// reused PID must not inherit the baselines of the process it replaced.
+	seen := make(map[string]*process.Process)
+	values := []types.ValueJSON{}
+
+	for _, matcher := range c.matchers {
+		var pids []int32
+
+		if matcher.Pidfile != "" {
+			pid, err := readPidfile(matcher.Pidfile)
+			if err == nil {
+				pids = append(pids, pid)
+			}
+		} else {
+			if all == nil {
+				var err error
+
+				all, err = process.ProcessesWithContext(ctx)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			for _, p := range all {
+				if matcher.match(ctx, p) {
+					pids = append(pids, p.Pid)
+				}
+			}
+		}
+
+		prefix := "proc." + matcher.Alias
+
+		var (
+			count, threads, fds   float64
+			cpuPercent, rss, vms  float64
+			readBytes, writeBytes int64
+			ioObserved            bool
+		)
+
+		for _, pid := range pids {
+			p, err := process.NewProcessWithContext(ctx, pid)
+			if err != nil {
+				continue
+			}
+
+			created, err := p.CreateTimeWithContext(ctx)
+			if err != nil {
+				continue
+			}
+
+			key := fmt.Sprintf("%s.%d.%d", prefix, pid, created)
+			if cached, ok := c.procs[key]; ok {
+				p = cached
+			}
+
+			memInfo, err := p.MemoryInfoWithContext(ctx)
+			if err != nil {
+				// The process exited between listing and reading.
+				continue
+			}
+
+			seen[key] = p
+			count++
+			rss += float64(memInfo.RSS)
+			vms += float64(memInfo.VMS)
+
+			if percent, err := p.PercentWithContext(ctx, 0); err == nil {
+				cpuPercent += percent
+			}
+
+			if n, err := p.NumThreadsWithContext(ctx); err == nil {
+				threads += float64(n)
+			}
+
+			if n, err := p.NumFDsWithContext(ctx); err == nil {
+				fds += float64(n)
+			}
+
+			if io, err := p.IOCountersWithContext(ctx); err == nil {
+				if delta, ok := c.io.observe(key+".read_bytes", io.ReadBytes); ok {
+					readBytes += delta
+					ioObserved = true
+				}
+
+				if delta, ok := c.io.observe(key+".write_bytes", io.WriteBytes); ok {
+					writeBytes += delta
+					ioObserved = true
+				}
+			}
+		}
+
+		values = append(values,
+			Gauge(prefix+".count", count),
+			Gauge(prefix+".cpu_percent", cpuPercent),
+			Gauge(prefix+".rss", rss),
+			Gauge(prefix+".vms", vms),
+			Gauge(prefix+".threads", threads),
+			Gauge(prefix+".fds", fds),
+		)
+
+		if ioObserved {
+			values = append(values,
+				Counter(prefix+".read_bytes", readBytes),
+				Counter(prefix+".write_bytes", writeBytes),
+			)
+		}
+	}
+
+	c.procs = seen
+	c.io.forget()
+
+	return values, nil
+}
+
+func (m ProcessMatcher) match(ctx context.Context, p *process.Process) bool {
+	if m.Name != "" {
+		name, err := p.NameWithContext(ctx)
+
+		return err == nil && name == m.Name
+	}
+
+	if m.Cmdline != nil {
+		cmdline, err := p.CmdlineWithContext(ctx)
+
+		return err == nil && m.Cmdline.MatchString(cmdline)
+	}
+
+	return false
+}
+
+func readPidfile(path string) (int32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(pid), nil
+}
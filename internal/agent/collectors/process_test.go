@@ -0,0 +1,113 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/agent/config"
+	"github.com/ustkit/cmas/internal/types"
+)
+
+func TestNewProcessMatchers(t *testing.T) {
+	tests := []struct {
+		name      string
+		processes []config.Process
+		want      []ProcessMatcher
+		wantErr   bool
+	}{
+		{
+			name:      "case 1",
+			processes: nil,
+			want:      []ProcessMatcher{},
+		},
+		{
+			name: "case 2",
+			processes: []config.Process{
+				{Alias: "nginx", Name: "nginx"},
+				{Alias: "api", Cmdline: "^/usr/bin/api"},
+				{Alias: "pg", Pidfile: "/run/pg.pid"},
+			},
+			want: []ProcessMatcher{
+				{Alias: "nginx", Name: "nginx"},
+				{Alias: "api", Cmdline: regexp.MustCompile("^/usr/bin/api")},
+				{Alias: "pg", Pidfile: "/run/pg.pid"},
+			},
+		},
+		{
+			name:      "case 3",
+			processes: []config.Process{{Alias: "api", Cmdline: "("}},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchers, err := NewProcessMatchers(tt.processes)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errProcessRule)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, matchers)
+		})
+	}
+}
+
+func TestProcess_Collect(t *testing.T) {
+	pidfile := filepath.Join(t.TempDir(), "self.pid")
+	require.NoError(t, os.WriteFile(pidfile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o600))
+
+	collector := NewProcess(0, []ProcessMatcher{
+		{Alias: "self", Pidfile: pidfile},
+		{Alias: "test", Cmdline: regexp.MustCompile(regexp.QuoteMeta(os.Args[0]))},
+		{Alias: "missing", Pidfile: filepath.Join(t.TempDir(), "missing.pid")},
+	})
+
+	for i := 0; i < 2; i++ {
+		values, err := collector.Collect(context.Background())
+		require.NoError(t, err)
+
+		got := make(map[string]types.Gauge)
+		for _, v := range values {
+			if v.MType == GAUGE {
+				got[v.ID] = *v.Value
+			}
+		}
+
+		assert.Equal(t, types.Gauge(1), got["proc.self.count"])
+		assert.NotZero(t, got["proc.self.rss"])
+		assert.NotZero(t, got["proc.self.threads"])
+		assert.GreaterOrEqual(t, got["proc.test.count"], types.Gauge(1))
+		assert.Equal(t, types.Gauge(0), got["proc.missing.count"])
+	}
+	// A handle cached for an earlier process with the same PID is dropped.
+	stale := fmt.Sprintf("proc.self.%d.1", os.Getpid())
+	collector.procs[stale] = collector.procs[fmt.Sprintf("proc.self.%d.%d", os.Getpid(), created(t))]
+	require.NotNil(t, collector.procs[stale])
+
+	_, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	assert.NotContains(t, collector.procs, stale)
+}
+
+func created(t *testing.T) int64 {
+	t.Helper()
+
+	p, err := process.NewProcess(int32(os.Getpid()))
+	require.NoError(t, err)
+
+	created, err := p.CreateTime()
+	require.NoError(t, err)
+
+	return created
+}
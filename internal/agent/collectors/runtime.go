@@ -0,0 +1,60 @@
+package collectors
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"github.com/ustkit/cmas/internal/types"
+)
+
+type Runtime struct {
+	interval time.Duration
+}
+
+func NewRuntime(interval time.Duration) *Runtime {
+	return &Runtime{interval: interval}
+}
+
+func (c *Runtime) Name() string {
+	return "runtime"
+}
+
+func (c *Runtime) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *Runtime) Collect(ctx context.Context) ([]types.ValueJSON, error) {
+	memStat := runtime.MemStats{}
+	runtime.ReadMemStats(&memStat)
+
+	return []types.ValueJSON{
+		Gauge("Alloc", float64(memStat.Alloc)),
+		Gauge("BuckHashSys", float64(memStat.BuckHashSys)),
+		Gauge("GCCPUFraction", memStat.GCCPUFraction),
+		Gauge("GCSys", float64(memStat.GCSys)),
+		Gauge("HeapAlloc", float64(memStat.HeapAlloc)),
+		Gauge("HeapIdle", float64(memStat.HeapIdle)),
+		Gauge("HeapInuse", float64(memStat.HeapInuse)),
+		Gauge("HeapObjects", float64(memStat.HeapObjects)),
+		Gauge("HeapReleased", float64(memStat.HeapReleased)),
+		Gauge("HeapSys", float64(memStat.HeapSys)),
+		Gauge("Lookups", float64(memStat.Lookups)),
+		Gauge("MCacheInuse", float64(memStat.MCacheInuse)),
+		Gauge("MCacheSys", float64(memStat.MCacheSys)),
+		Gauge("MSpanInuse", float64(memStat.MSpanInuse)),
+		Gauge("MSpanSys", float64(memStat.MSpanSys)),
+		Gauge("Mallocs", float64(memStat.Mallocs)),
+		Gauge("NextGC", float64(memStat.NextGC)),
+		Gauge("NumForcedGC", float64(memStat.NumForcedGC)),
+		Gauge("OtherSys", float64(memStat.OtherSys)),
+		Gauge("PauseTotalNs", float64(memStat.PauseTotalNs)),
+		Gauge("StackInuse", float64(memStat.StackInuse)),
+		Gauge("StackSys", float64(memStat.StackSys)),
+		Gauge("Sys", float64(memStat.Sys)),
+		Gauge("TotalAlloc", float64(memStat.TotalAlloc)),
+		Gauge("Frees", float64(memStat.Frees)),
+		Gauge("LastGC", float64(memStat.LastGC)),
+		Gauge("NumGC", float64(memStat.NumGC)),
+	}, nil
+}
@@ -0,0 +1,185 @@
+package collectors
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ustkit/cmas/internal/types"
+)
+
+var histogramQuantiles = []struct {
+	suffix string
+	q      float64
+}{
+	{".p50", 0.5},
+	{".p90", 0.9},
+	{".p99", 0.99},
+}
+
+// RuntimeMetrics reports every metric of the runtime/metrics package.
+// Cumulative integer metrics become counters, everything else gauges, and
+// histograms are flattened into quantiles of the samples observed since the
+// previous poll.
+type RuntimeMetrics struct {
+	interval time.Duration
+	samples  []metrics.Sample
+	names    map[string]string
+	counters map[string]bool
+
+	mu         *sync.Mutex
+	totals     *deltas
+	histograms map[string]*metrics.Float64Histogram
+}
+
+func NewRuntimeMetrics(interval time.Duration) *RuntimeMetrics {
+	descriptions := metrics.All()
+
+	c := &RuntimeMetrics{
+		interval: interval,
+		samples:  make([]metrics.Sample, 0, len(descriptions)),
+		names:    make(map[string]string, len(descriptions)),
+		counters: make(map[string]bool),
+
+		mu:         &sync.Mutex{},
+		totals:     newDeltas(),
+		histograms: make(map[string]*metrics.Float64Histogram),
+	}
+
+	for _, d := range descriptions {
+		if d.Kind == metrics.KindBad {
+			continue
+		}
+
+		c.samples = append(c.samples, metrics.Sample{Name: d.Name})
+		c.names[d.Name] = runtimeMetricName(d.Name)
+		c.counters[d.Name] = d.Cumulative && d.Kind == metrics.KindUint64
+	}
+
+	return c
+}
+
+func (c *RuntimeMetrics) Name() string {
+	return "runtime_metrics"
+}
+
+func (c *RuntimeMetrics) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *RuntimeMetrics) Collect(ctx context.Context) ([]types.ValueJSON, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metrics.Read(c.samples)
+
+	values := make([]types.ValueJSON, 0, len(c.samples))
+
+	for _, sample := range c.samples {
+		name := c.names[sample.Name]
+
+		switch sample.Value.Kind() {
+		case metrics.KindUint64:
+			if !c.counters[sample.Name] {
+				values = append(values, Gauge(name, float64(sample.Value.Uint64())))
+
+				continue
+			}
+
+			if delta, ok := c.totals.observe(name, sample.Value.Uint64()); ok {
+				values = append(values, Counter(name, delta))
+			}
+		case metrics.KindFloat64:
+			values = append(values, Gauge(name, sample.Value.Float64()))
+		case metrics.KindFloat64Histogram:
+			hist := sample.Value.Float64Histogram()
+			window := histogramDelta(c.histograms[sample.Name], hist)
+			c.histograms[sample.Name] = copyHistogram(hist)
+
+			for _, quantile := range histogramQuantiles {
+				values = append(values, Gauge(name+quantile.suffix, histogramQuantile(window, quantile.q)))
+			}
+		case metrics.KindBad:
+		}
+	}
+
+	return values, nil
+}
+
+// runtimeMetricName maps "/gc/heap/allocs:bytes" to
+// "runtime.gc.heap.allocs.bytes".
+func runtimeMetricName(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	name = strings.NewReplacer("/", ".", ":", ".").Replace(name)
+
+	return "runtime." + strings.Map(func(r rune) rune {
+		if r == '.' || r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+
+		return '_'
+	}, name)
+}
+
+func copyHistogram(hist *metrics.Float64Histogram) *metrics.Float64Histogram {
+	return &metrics.Float64Histogram{
+		Counts:  append([]uint64(nil), hist.Counts...),
+		Buckets: hist.Buckets,
+	}
+}
+
+// histogramDelta returns the samples added to cur since prev. Without a
+// compatible previous snapshot the whole histogram is used.
+func histogramDelta(prev, cur *metrics.Float64Histogram) *metrics.Float64Histogram {
+	if prev == nil || len(prev.Counts) != len(cur.Counts) {
+		return cur
+	}
+
+	counts := make([]uint64, len(cur.Counts))
+
+	for i := range cur.Counts {
+		if cur.Counts[i] >= prev.Counts[i] {
+			counts[i] = cur.Counts[i] - prev.Counts[i]
+		}
+	}
+
+	return &metrics.Float64Histogram{Counts: counts, Buckets: cur.Buckets}
+}
+
+// histogramQuantile returns the upper bound of the bucket holding the q-th
+// quantile, or the lower bound for the open-ended last bucket.
+func histogramQuantile(hist *metrics.Float64Histogram, q float64) float64 {
+	total := uint64(0)
+	for _, n := range hist.Counts {
+		total += n
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(total)))
+	seen := uint64(0)
+
+	for i, n := range hist.Counts {
+		seen += n
+		if seen < rank {
+			continue
+		}
+
+		if upper := hist.Buckets[i+1]; !math.IsInf(upper, 0) {
+			return upper
+		}
+
+		if lower := hist.Buckets[i]; !math.IsInf(lower, 0) {
+			return lower
+		}
+
+		return 0
+	}
+
+	return 0
+}
@@ -0,0 +1,62 @@
+package collectors
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeMetricName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "/gc/heap/allocs:bytes", want: "runtime.gc.heap.allocs.bytes"},
+		{name: "/sched/latencies:seconds", want: "runtime.sched.latencies.seconds"},
+		{name: "/cpu/classes/gc/mark/assist:cpu-seconds", want: "runtime.cpu.classes.gc.mark.assist.cpu_seconds"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, runtimeMetricName(tt.name))
+		})
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	hist := &metrics.Float64Histogram{
+		Counts:  []uint64{5, 4, 1},
+		Buckets: []float64{math.Inf(-1), 1, 2, math.Inf(1)},
+	}
+
+	assert.Equal(t, 1.0, histogramQuantile(hist, 0.5))
+	assert.Equal(t, 2.0, histogramQuantile(hist, 0.9))
+	assert.Equal(t, 2.0, histogramQuantile(hist, 0.99))
+	assert.Equal(t, 0.0, histogramQuantile(&metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}, 0.5))
+
+	prev := &metrics.Float64Histogram{Counts: []uint64{5, 0, 0}, Buckets: hist.Buckets}
+	assert.Equal(t, 2.0, histogramQuantile(histogramDelta(prev, hist), 0.5))
+}
+
+func TestRuntimeMetrics_Collect(t *testing.T) {
+	collector := NewRuntimeMetrics(0)
+
+	_, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	values, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	types := make(map[string]string)
+	for _, v := range values {
+		types[v.ID] = v.MType
+	}
+
+	assert.Equal(t, COUNTER, types["runtime.gc.heap.allocs.bytes"])
+	assert.Equal(t, GAUGE, types["runtime.sched.goroutines.goroutines"])
+	assert.Equal(t, GAUGE, types["runtime.sched.latencies.seconds.p99"])
+}
@@ -0,0 +1,23 @@
+package collectors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntime_Collect(t *testing.T) {
+	values, err := NewRuntime(0).Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, values, 27)
+
+	counter := 0
+	for _, v := range values {
+		if v.MType == GAUGE && *v.Value != 0 {
+			counter++
+		}
+	}
+	assert.NotEqual(t, 0, counter)
+}
@@ -0,0 +1,316 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ustkit/cmas/internal/types"
+)
+
+var (
+	errStatsDLine     = errors.New("invalid statsd line")
+	errStatsDNoListen = errors.New("statsd collector needs an address or a socket")
+)
+
+// Listener is implemented by collectors that receive pushed metrics and
+// have to run in the background next to the polling loop.
+type Listener interface {
+	Listen(ctx context.Context) error
+}
+
+type statsdTimer struct {
+	samples []float64
+	count   float64
+}
+
+// StatsD accepts StatsD datagrams ("name:value|type[|@rate]") over UDP and,
+// optionally, a Unix datagram socket and aggregates them until the next
+// Collect: counters are summed, gauges keep the last value, timers are
+// reduced to count/min/max/mean/percentiles and sets to their cardinality.
+// Sampled counts are scaled up and rarely whole; the fraction a window does
+// not report is carried over to the next one.
+type StatsD struct {
+	interval time.Duration
+	address  string
+	socket   string
+
+	mu         *sync.Mutex
+	counters   map[string]float64
+	remainders map[string]float64
+	gauges     map[string]float64
+	updated    map[string]bool
+	timers     map[string]*statsdTimer
+	sets       map[string]map[string]bool
+}
+
+func NewStatsD(interval time.Duration, address, socket string) *StatsD {
+	c := &StatsD{
+		interval: interval,
+		address:  address,
+		socket:   socket,
+
+		mu:         &sync.Mutex{},
+		remainders: make(map[string]float64),
+		gauges:     make(map[string]float64),
+	}
+	c.reset()
+
+	return c
+}
+
+func (c *StatsD) Name() string {
+	return "statsd"
+}
+
+func (c *StatsD) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *StatsD) Listen(ctx context.Context) error {
+	if c.address == "" && c.socket == "" {
+		return errStatsDNoListen
+	}
+
+	conns := []net.PacketConn{}
+
+	if c.address != "" {
+		conn, err := net.ListenPacket("udp", c.address)
+		if err != nil {
+			return err
+		}
+
+		conns = append(conns, conn)
+	}
+
+	if c.socket != "" {
+		err := os.Remove(c.socket)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		conn, err := net.ListenPacket("unixgram", c.socket)
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+
+			return err
+		}
+
+		defer os.Remove(c.socket)
+
+		conns = append(conns, conn)
+	}
+
+	wg := &sync.WaitGroup{}
+
+	for _, conn := range conns {
+		wg.Add(1)
+
+		go func(conn net.PacketConn) {
+			defer wg.Done()
+			c.serve(ctx, conn)
+		}(conn)
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+func (c *StatsD) serve(ctx context.Context, conn net.PacketConn) {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 65535)
+
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("statsd collector: %s", err)
+			}
+
+			return
+		}
+
+		c.handle(buf[:n])
+	}
+}
+
+func (c *StatsD) handle(packet []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, line := range strings.Split(string(packet), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		err := c.add(line)
+		if err != nil {
+			log.Printf("statsd collector: %s", err)
+		}
+	}
+}
+
+func (c *StatsD) add(line string) error {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return fmt.Errorf("%w: %q", errStatsDLine, line)
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return fmt.Errorf("%w: %q", errStatsDLine, line)
+	}
+
+	name = statsdName(name)
+	value, mType := parts[0], parts[1]
+	rate := 1.0
+
+	for _, part := range parts[2:] {
+		if strings.HasPrefix(part, "@") {
+			r, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || r <= 0 || r > 1 {
+				return fmt.Errorf("%w: bad sample rate in %q", errStatsDLine, line)
+			}
+
+			rate = r
+		}
+	}
+
+	if mType == "s" {
+		if c.sets[name] == nil {
+			c.sets[name] = make(map[string]bool)
+		}
+
+		c.sets[name][value] = true
+
+		return nil
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("%w: bad value in %q", errStatsDLine, line)
+	}
+
+	switch mType {
+	case "c":
+		c.counters[name] += number / rate
+	case "g":
+		if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+			number += c.gauges[name]
+		}
+
+		c.gauges[name] = number
+		c.updated[name] = true
+	case "ms", "h":
+		timer, ok := c.timers[name]
+		if !ok {
+			timer = &statsdTimer{}
+			c.timers[name] = timer
+		}
+
+		timer.samples = append(timer.samples, number)
+		timer.count += 1 / rate
+	default:
+		return fmt.Errorf("%w: unknown type in %q", errStatsDLine, line)
+	}
+
+	return nil
+}
+
+func (c *StatsD) Collect(ctx context.Context) ([]types.ValueJSON, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := []types.ValueJSON{}
+
+	for name, sum := range c.counters {
+		values = append(values, Counter(name, c.whole(name, sum)))
+	}
+
+	for name := range c.updated {
+		values = append(values, Gauge(name, c.gauges[name]))
+	}
+
+	for name, timer := range c.timers {
+		sort.Float64s(timer.samples)
+
+		sum := 0.0
+		for _, sample := range timer.samples {
+			sum += sample
+		}
+
+		values = append(values,
+			Counter(name+".count", c.whole(name+".count", timer.count)),
+			Gauge(name+".min", timer.samples[0]),
+			Gauge(name+".max", timer.samples[len(timer.samples)-1]),
+			Gauge(name+".mean", sum/float64(len(timer.samples))),
+			Gauge(name+".p50", percentile(timer.samples, 0.5)),
+			Gauge(name+".p90", percentile(timer.samples, 0.9)),
+			Gauge(name+".p99", percentile(timer.samples, 0.99)),
+		)
+	}
+
+	for name, set := range c.sets {
+		values = append(values, Gauge(name, float64(len(set))))
+	}
+
+	c.reset()
+
+	return values, nil
+}
+
+// whole returns the whole part of count plus what the previous windows of
+// name left over and keeps the new fraction for the next one.
+func (c *StatsD) whole(name string, count float64) int64 {
+	count += c.remainders[name]
+	whole := math.Round(count)
+	c.remainders[name] = count - whole
+
+	return int64(whole)
+}
+
+// reset starts a new aggregation window. Gauge values are kept so that
+// relative updates ("+3|g") apply to the last known value.
+func (c *StatsD) reset() {
+	c.counters = make(map[string]float64)
+	c.updated = make(map[string]bool)
+	c.timers = make(map[string]*statsdTimer)
+	c.sets = make(map[string]map[string]bool)
+}
+
+// percentile uses the nearest-rank method on sorted samples.
+func percentile(sorted []float64, q float64) float64 {
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return sorted[rank]
+}
+
+func statsdName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == ' ' || r == '\t' {
+			return '_'
+		}
+
+		return r
+	}, name)
+}
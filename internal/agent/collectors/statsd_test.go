@@ -0,0 +1,118 @@
+package collectors
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/types"
+)
+
+func collectMap(t *testing.T, c Collector) (map[string]types.Gauge, map[string]types.Counter) {
+	t.Helper()
+
+	values, err := c.Collect(context.Background())
+	require.NoError(t, err)
+
+	gauges := make(map[string]types.Gauge)
+	deltas := make(map[string]types.Counter)
+
+	for _, v := range values {
+		switch v.MType {
+		case GAUGE:
+			gauges[v.ID] = *v.Value
+		case COUNTER:
+			deltas[v.ID] = *v.Delta
+		}
+	}
+
+	return gauges, deltas
+}
+
+func TestStatsD_Aggregate(t *testing.T) {
+	collector := NewStatsD(0, "", "")
+	collector.handle([]byte("requests:1|c\nrequests:2|c|@0.5\nqueue:10|g\nqueue:-3|g\n" +
+		"latency:10|ms\nlatency:20|ms\nlatency:30|ms|@0.5\nusers:alice|s\nusers:bob|s\nusers:alice|s\n" +
+		"broken\nbad:x|c\nunknown:1|z\n"))
+
+	gauges, deltas := collectMap(t, collector)
+
+	assert.Equal(t, map[string]types.Counter{
+		"requests":      5,
+		"latency.count": 4,
+	}, deltas)
+	assert.Equal(t, map[string]types.Gauge{
+		"queue":        7,
+		"latency.min":  10,
+		"latency.max":  30,
+		"latency.mean": 20,
+		"latency.p50":  20,
+		"latency.p90":  30,
+		"latency.p99":  30,
+		"users":        2,
+	}, gauges)
+
+	gauges, deltas = collectMap(t, collector)
+	assert.Empty(t, gauges)
+	assert.Empty(t, deltas)
+
+	collector.handle([]byte("queue:+1|g"))
+
+	gauges, _ = collectMap(t, collector)
+	assert.Equal(t, map[string]types.Gauge{"queue": 8}, gauges)
+}
+
+func TestStatsD_SampledCounterRemainder(t *testing.T) {
+	collector := NewStatsD(0, "", "")
+	total := types.Counter(0)
+
+	// Each window counts 1/0.3 = 3.33 hits, so ten windows report 33 and
+	// not ten times the rounded 3.
+	for i := 0; i < 10; i++ {
+		collector.handle([]byte("hits:1|c|@0.3"))
+
+		_, deltas := collectMap(t, collector)
+		total += deltas["hits"]
+	}
+
+	assert.Equal(t, types.Counter(33), total)
+}
+
+func TestStatsD_ListenUnconfigured(t *testing.T) {
+	err := NewStatsD(0, "", "").Listen(context.Background())
+	assert.ErrorIs(t, err, errStatsDNoListen)
+}
+
+func TestStatsD_Serve(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	collector := NewStatsD(0, "", "")
+
+	go func() {
+		collector.serve(ctx, conn)
+		close(done)
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("hits:3|c"))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		collector.mu.Lock()
+		defer collector.mu.Unlock()
+
+		return collector.counters["hits"] == 3
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
@@ -1,9 +1,258 @@
 package config
 
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ustkit/cmas/internal/configloader"
+	"github.com/ustkit/cmas/internal/types"
+)
+
+var ErrInvalid = errors.New("invalid agent config")
+
 type Config struct {
-	Sever          string `env:"ADDRESS"`
-	PollInterval   string `env:"POLL_INTERVAL"`
-	ReportInterval string `env:"REPORT_INTERVAL"`
-	DataType       string
-	Key            string `env:"KEY"`
+	Sever           string        `env:"ADDRESS" yaml:"address"`
+	ID              string        `env:"AGENT_ID" yaml:"id"`
+	PollInterval    time.Duration `env:"POLL_INTERVAL" yaml:"poll_interval"`
+	ReportInterval  time.Duration `env:"REPORT_INTERVAL" yaml:"report_interval"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" yaml:"shutdown_timeout"`
+	DataType        string        `yaml:"data_type"`
+	Key             string        `env:"KEY" yaml:"key" secret:"true"`
+	RateLimit       int           `env:"RATE_LIMIT" yaml:"rate_limit"`
+	GzipMinSize     int           `env:"GZIP_MIN_SIZE" yaml:"gzip_min_size"`
+	CryptoKey       string        `env:"CRYPTO_KEY" yaml:"crypto_key"`
+	Collectors      []string      `env:"COLLECTORS" yaml:"collectors"`
+	// Processes select what the process collector reports; being a list
+	// of rules they are only read from the config file.
+	Processes []Process `yaml:"processes"`
+	// Labels are put on every reported metric; host defaults to the
+	// hostname.
+	Labels configloader.Map `env:"LABELS" yaml:"labels"`
+
+	TLS    TLS    `envPrefix:"TLS_" yaml:"tls"`
+	Outbox Outbox `envPrefix:"OUTBOX_" yaml:"outbox"`
+	Retry  Retry  `envPrefix:"RETRY_" yaml:"retry"`
+	Disk   Disk   `envPrefix:"DISK_" yaml:"disk"`
+	Net    Net    `envPrefix:"NET_" yaml:"net"`
+	StatsD StatsD `envPrefix:"STATSD_" yaml:"statsd"`
+
+	Cgroup       string `env:"CGROUP" yaml:"cgroup"`
+	CgroupRoot   string `env:"CGROUP_ROOT" yaml:"cgroup_root"`
+	PressureRoot string `env:"PRESSURE_ROOT" yaml:"pressure_root"`
+}
+
+type TLS struct {
+	Enabled    bool   `env:"ENABLED" yaml:"enabled"`
+	CA         string `env:"CA" yaml:"ca"`
+	Cert       string `env:"CERT" yaml:"cert"`
+	Key        string `env:"KEY" yaml:"key"`
+	ServerName string `env:"SERVER_NAME" yaml:"server_name"`
+}
+
+// On reports whether reports go over TLS: any TLS setting implies it.
+func (t TLS) On() bool {
+	return t.Enabled || t.CA != "" || t.Cert != "" || t.Key != "" || t.ServerName != ""
+}
+
+type Outbox struct {
+	Dir     string        `env:"DIR" yaml:"dir"`
+	MaxSize int64         `env:"MAX_SIZE" yaml:"max_size"`
+	MaxAge  time.Duration `env:"MAX_AGE" yaml:"max_age"`
+}
+
+type Retry struct {
+	MaxAttempts int           `env:"MAX_ATTEMPTS" yaml:"max_attempts"`
+	BaseDelay   time.Duration `env:"BASE_DELAY" yaml:"base_delay"`
+	MaxDelay    time.Duration `env:"MAX_DELAY" yaml:"max_delay"`
+	Jitter      float64       `env:"JITTER" yaml:"jitter"`
+}
+
+type Disk struct {
+	Mountpoints        []string `env:"MOUNTPOINTS" yaml:"mountpoints"`
+	MountpointsExclude []string `env:"MOUNTPOINTS_EXCLUDE" yaml:"mountpoints_exclude"`
+	Fstypes            []string `env:"FSTYPES" yaml:"fstypes"`
+	FstypesExclude     []string `env:"FSTYPES_EXCLUDE" yaml:"fstypes_exclude"`
+}
+
+type Net struct {
+	Interfaces        []string `env:"INTERFACES" yaml:"interfaces"`
+	InterfacesExclude []string `env:"INTERFACES_EXCLUDE" yaml:"interfaces_exclude"`
+}
+
+// Process selects the processes reported under Alias by exactly one of
+// Name, Cmdline (a regular expression) or Pidfile.
+type Process struct {
+	Alias   string `yaml:"alias"`
+	Name    string `yaml:"name"`
+	Cmdline string `yaml:"cmdline"`
+	Pidfile string `yaml:"pidfile"`
+}
+
+func (p Process) validate() error {
+	rules := 0
+
+	for _, rule := range []string{p.Name, p.Cmdline, p.Pidfile} {
+		if rule != "" {
+			rules++
+		}
+	}
+
+	switch {
+	case p.Alias == "":
+		return fmt.Errorf("%w: processes: alias is empty", ErrInvalid)
+	case rules != 1:
+		return fmt.Errorf("%w: processes: %s needs exactly one of name, cmdline or pidfile", ErrInvalid, p.Alias)
+	}
+
+	_, err := regexp.Compile(p.Cmdline)
+	if err != nil {
+		return fmt.Errorf("%w: processes: %s: %s", ErrInvalid, p.Alias, err)
+	}
+
+	return nil
+}
+
+type StatsD struct {
+	Address string `env:"ADDRESS" yaml:"address"`
+	Socket  string `env:"SOCKET" yaml:"socket"`
+}
+
+func Default() *Config {
+	hostname, _ := os.Hostname()
+
+	return &Config{
+		Sever:           "localhost:8080",
+		ID:              hostname,
+		PollInterval:    2 * time.Second,
+		ReportInterval:  10 * time.Second,
+		ShutdownTimeout: 5 * time.Second,
+		DataType:        "jsonbatch",
+		RateLimit:       10,
+		GzipMinSize:     1024,
+		Collectors:      []string{"runtime", "memory", "cpu", "disk", "network", "host", "pressure"},
+		Outbox:          Outbox{MaxSize: 64 << 20, MaxAge: 24 * time.Hour},
+		Retry:           Retry{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second, Jitter: 0.2},
+		Net:             Net{InterfacesExclude: []string{"lo", "veth*"}},
+		StatsD:          StatsD{Address: "localhost:8125"},
+		Cgroup:          "auto",
+		CgroupRoot:      "/sys/fs/cgroup",
+		PressureRoot:    "/proc/pressure",
+	}
+}
+
+// Load builds the agent config from the defaults, the config file (-c or
+// CONFIG), the environment and args, in increasing priority, and validates
+// the result.
+func Load(args []string) (*Config, error) {
+	agentConfig := Default()
+
+	err := configloader.Load(agentConfig, "agent", args, bind)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := agentConfig.Labels["host"]; !ok {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+
+		if agentConfig.Labels == nil {
+			agentConfig.Labels = configloader.Map{}
+		}
+
+		agentConfig.Labels["host"] = hostname
+	}
+
+	return agentConfig, agentConfig.Validate()
+}
+
+func bind(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.Sever, "a", c.Sever, "server address")
+	fs.StringVar(&c.ID, "id", c.ID, "agent ID the server tracks resent batches by, the hostname by default")
+	fs.DurationVar(&c.PollInterval, "p", c.PollInterval, "poll interval")
+	fs.DurationVar(&c.ReportInterval, "r", c.ReportInterval, "report interval")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "time limit for the final report on shutdown")
+	fs.StringVar(&c.DataType, "t", c.DataType, "data type: plain, json, jsonbatch or grpc (-a is then the gRPC address)")
+	fs.StringVar(&c.Key, "k", c.Key, "data signing key")
+	fs.StringVar(&c.CryptoKey, "crypto-key", c.CryptoKey, "server public key file for encrypting reports")
+	fs.BoolVar(&c.TLS.Enabled, "tls", c.TLS.Enabled, "send reports over HTTPS")
+	fs.StringVar(&c.TLS.CA, "tls-ca", c.TLS.CA, "CA bundle for verifying the server, implies -tls")
+	fs.StringVar(&c.TLS.Cert, "tls-cert", c.TLS.Cert, "client certificate file for mTLS, implies -tls")
+	fs.StringVar(&c.TLS.Key, "tls-key", c.TLS.Key, "client private key file for mTLS, implies -tls")
+	fs.StringVar(&c.TLS.ServerName, "tls-server-name", c.TLS.ServerName, "expected server name in its certificate, implies -tls")
+	fs.IntVar(&c.GzipMinSize, "z", c.GzipMinSize, "gzip request bodies from this size in bytes, -1 disables")
+	fs.IntVar(&c.RateLimit, "l", c.RateLimit, "max concurrent requests in plain and json modes")
+	fs.StringVar(&c.Outbox.Dir, "o", c.Outbox.Dir, "outbox directory for unsent reports")
+	fs.Int64Var(&c.Outbox.MaxSize, "outbox-max-size", c.Outbox.MaxSize, "outbox size limit in bytes")
+	fs.DurationVar(&c.Outbox.MaxAge, "outbox-max-age", c.Outbox.MaxAge, "outbox report age limit")
+	configloader.ListVar(fs, &c.Collectors, "collectors", "enabled collectors")
+	fs.Var(&c.Labels, "labels", "labels put on every metric as name=value pairs, host defaults to the hostname")
+}
+
+func (c *Config) Validate() error {
+	switch {
+	case c.Sever == "":
+		return fmt.Errorf("%w: address is empty", ErrInvalid)
+	case c.PollInterval <= 0:
+		return fmt.Errorf("%w: poll_interval must be positive, got %s", ErrInvalid, c.PollInterval)
+	case c.ReportInterval <= 0:
+		return fmt.Errorf("%w: report_interval must be positive, got %s", ErrInvalid, c.ReportInterval)
+	case c.ShutdownTimeout <= 0:
+		return fmt.Errorf("%w: shutdown_timeout must be positive, got %s", ErrInvalid, c.ShutdownTimeout)
+	case c.RateLimit <= 0:
+		return fmt.Errorf("%w: rate_limit must be positive, got %d", ErrInvalid, c.RateLimit)
+	case c.Outbox.MaxSize < 0 || c.Outbox.MaxAge < 0:
+		return fmt.Errorf("%w: outbox limits must not be negative", ErrInvalid)
+	case c.Retry.MaxAttempts < 1:
+		return fmt.Errorf("%w: retry.max_attempts must be at least 1, got %d", ErrInvalid, c.Retry.MaxAttempts)
+	case c.Retry.BaseDelay < 0 || c.Retry.MaxDelay < 0:
+		return fmt.Errorf("%w: retry delays must not be negative", ErrInvalid)
+	case c.Retry.Jitter < 0 || c.Retry.Jitter > 1:
+		return fmt.Errorf("%w: retry.jitter must be between 0 and 1, got %g", ErrInvalid, c.Retry.Jitter)
+	case (c.TLS.Cert == "") != (c.TLS.Key == ""):
+		return fmt.Errorf("%w: tls.cert and tls.key must be set together", ErrInvalid)
+	}
+
+	err := types.Labels(c.Labels).Validate()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+
+	switch c.DataType {
+	case "plain", "json", "jsonbatch", "grpc":
+	default:
+		return fmt.Errorf("%w: unknown data_type %q", ErrInvalid, c.DataType)
+	}
+
+	// Only batches are encrypted; the other modes would send plain text.
+	if c.CryptoKey != "" && c.DataType != "jsonbatch" {
+		return fmt.Errorf("%w: crypto_key needs data_type jsonbatch, got %q", ErrInvalid, c.DataType)
+	}
+
+	switch c.Cgroup {
+	case "auto", "on", "off":
+	default:
+		return fmt.Errorf("%w: cgroup must be auto, on or off, got %q", ErrInvalid, c.Cgroup)
+	}
+
+	for _, process := range c.Processes {
+		err = process.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range c.Collectors {
+		if strings.TrimSpace(name) == "statsd" && c.StatsD.Address == "" && c.StatsD.Socket == "" {
+			return fmt.Errorf("%w: the statsd collector needs statsd.address or statsd.socket", ErrInvalid)
+		}
+	}
+
+	return nil
 }
@@ -1,9 +1,122 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/configloader"
 )
 
 func TestAgentCongig(t *testing.T) {
 	t.Skip()
 }
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+address: metrics.local:8080
+report_interval: 30s
+collectors: [runtime, cpu]
+retry:
+  max_attempts: 5
+disk:
+  fstypes_exclude: [tmpfs, overlay]
+labels:
+  env: prod
+processes:
+  - alias: nginx
+    name: nginx
+  - alias: api
+    cmdline: ^/usr/bin/api
+`), 0o600))
+
+	t.Setenv("RETRY_BASE_DELAY", "1s")
+
+	agentConfig, err := Load([]string{"-c", path, "-p", "5s"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "metrics.local:8080", agentConfig.Sever)
+	assert.Equal(t, 5*time.Second, agentConfig.PollInterval)
+	assert.Equal(t, 30*time.Second, agentConfig.ReportInterval)
+	assert.Equal(t, []string{"runtime", "cpu"}, agentConfig.Collectors)
+	assert.Equal(t, Retry{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 2 * time.Second, Jitter: 0.2}, agentConfig.Retry)
+	assert.Equal(t, []string{"tmpfs", "overlay"}, agentConfig.Disk.FstypesExclude)
+	assert.Equal(t, []string{"lo", "veth*"}, agentConfig.Net.InterfacesExclude)
+	assert.Equal(t, []Process{{Alias: "nginx", Name: "nginx"}, {Alias: "api", Cmdline: "^/usr/bin/api"}}, agentConfig.Processes)
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	assert.Equal(t, configloader.Map{"env": "prod", "host": hostname}, agentConfig.Labels)
+
+	t.Setenv("LABELS", "host=web1")
+
+	agentConfig, err = Load([]string{"-c", path})
+	require.NoError(t, err)
+	assert.Equal(t, configloader.Map{"host": "web1"}, agentConfig.Labels)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr bool
+	}{
+		{name: "case 1", modify: func(c *Config) {}},
+		{name: "case 2", modify: func(c *Config) { c.PollInterval = 0 }, wantErr: true},
+		{name: "case 3", modify: func(c *Config) { c.DataType = "xml" }, wantErr: true},
+		{name: "case 4", modify: func(c *Config) { c.Retry.Jitter = 2 }, wantErr: true},
+		{name: "case 5", modify: func(c *Config) { c.TLS.Cert = "agent.crt" }, wantErr: true},
+		{name: "case 6", modify: func(c *Config) { c.Cgroup = "maybe" }, wantErr: true},
+		{name: "case 7", modify: func(c *Config) { c.DataType = "grpc" }},
+		{name: "case 8", modify: func(c *Config) { c.ShutdownTimeout = 0 }, wantErr: true},
+		{name: "case 9", modify: func(c *Config) { c.Labels = configloader.Map{"bad-name": "x"} }, wantErr: true},
+		{name: "case 10", modify: func(c *Config) { c.DataType, c.CryptoKey = "grpc", "server.pub" }, wantErr: true},
+		{name: "case 11", modify: func(c *Config) { c.DataType, c.CryptoKey = "jsonbatch", "server.pub" }},
+		{name: "case 12", modify: func(c *Config) { c.Collectors, c.StatsD = []string{"statsd"}, StatsD{} }, wantErr: true},
+		{name: "case 13", modify: func(c *Config) { c.Collectors, c.StatsD = []string{"statsd"}, StatsD{Socket: "/run/statsd.sock"} }},
+		{name: "case 14", modify: func(c *Config) { c.Processes = []Process{{Alias: "pg", Pidfile: "/run/pg.pid"}} }},
+		{name: "case 15", modify: func(c *Config) { c.Processes = []Process{{Name: "nginx"}} }, wantErr: true},
+		{name: "case 16", modify: func(c *Config) { c.Processes = []Process{{Alias: "nginx"}} }, wantErr: true},
+		{name: "case 17", modify: func(c *Config) { c.Processes = []Process{{Alias: "nginx", Name: "nginx", Pidfile: "/run/nginx.pid"}} }, wantErr: true},
+		{name: "case 18", modify: func(c *Config) { c.Processes = []Process{{Alias: "api", Cmdline: "("}} }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agentConfig := Default()
+			tt.modify(agentConfig)
+
+			err := agentConfig.Validate()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalid)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestTLS_On(t *testing.T) {
+	tests := []struct {
+		name string
+		tls  TLS
+		want bool
+	}{
+		{name: "case 1", tls: TLS{}, want: false},
+		{name: "case 2", tls: TLS{Enabled: true}, want: true},
+		{name: "case 3", tls: TLS{CA: "ca.pem"}, want: true},
+		{name: "case 4", tls: TLS{Cert: "agent.crt", Key: "agent.key"}, want: true},
+		{name: "case 5", tls: TLS{Key: "agent.key"}, want: true},
+		{name: "case 6", tls: TLS{ServerName: "metrics.internal"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.tls.On())
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ustkit/cmas/internal/agent/config"
+	"github.com/ustkit/cmas/internal/agent/outbox"
+	pb "github.com/ustkit/cmas/internal/proto"
+	"github.com/ustkit/cmas/internal/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type grpcError struct {
+	err error
+}
+
+func (e *grpcError) Error() string {
+	return e.err.Error()
+}
+
+func (e *grpcError) Unwrap() error {
+	return e.err
+}
+
+func (e *grpcError) Temporary() bool {
+	switch status.Code(e.err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+
+	return false
+}
+
+func (e *grpcError) rejected() bool {
+	switch status.Code(e.err) {
+	case codes.InvalidArgument, codes.Unimplemented:
+		return true
+	}
+
+	return false
+}
+
+// SendGRPC is SendBatch over the Metrics gRPC service. Batches go through the
+// same outbox as JSON ones, so switching transports keeps queued reports.
+// That is why the request is built from the encoded JSON batch, even the one
+// just made by nextBatch: a replay only has the queued bytes, which may also
+// have been written by an agent running the HTTP transport.
+func (metrics *Metrics) SendGRPC(ctx context.Context, client pb.MetricsClient, agentConfig *config.Config) error {
+	batch, totals, err := metrics.nextBatch(agentConfig)
+	if err != nil {
+		return err
+	}
+
+	metrics.mu.Lock()
+	policy := metrics.retry
+	metrics.mu.Unlock()
+
+	return metrics.deliver(batch, totals, func(batch queuedBatch) error {
+		valuesJSON := []types.ValueJSON{}
+
+		err := json.Unmarshal(batch.Metrics, &valuesJSON)
+		if err != nil {
+			return fmt.Errorf("%w: %s", outbox.ErrDrop, err)
+		}
+
+		req := &pb.UpdateBatchRequest{Metrics: make([]*pb.Metric, 0, len(valuesJSON))}
+		if batch.ID != "" {
+			req.BatchId = batch.ID
+			req.AgentId = batch.Agent
+		}
+
+		for _, valueJSON := range valuesJSON {
+			req.Metrics = append(req.Metrics, metricToProto(valueJSON))
+		}
+
+		options := []grpc.CallOption{}
+		if agentConfig.GzipMinSize >= 0 && proto.Size(req) >= agentConfig.GzipMinSize {
+			options = append(options, grpc.UseCompressor(gzip.Name))
+		}
+
+		return policy.Do(ctx, func() error {
+			_, err := client.UpdateBatch(ctx, req, options...)
+			if err != nil {
+				return &grpcError{err: err}
+			}
+
+			return nil
+		})
+	})
+}
+
+func metricToProto(valueJSON types.ValueJSON) *pb.Metric {
+	metric := &pb.Metric{Id: valueJSON.ID, Hash: valueJSON.Hash, Labels: valueJSON.Labels}
+
+	switch valueJSON.MType {
+	case GAUGE:
+		metric.Type = pb.Metric_GAUGE
+	case COUNTER:
+		metric.Type = pb.Metric_COUNTER
+	}
+
+	if valueJSON.Value != nil {
+		metric.Value = float64(*valueJSON.Value)
+	}
+
+	if valueJSON.Delta != nil {
+		metric.Delta = int64(*valueJSON.Delta)
+	}
+
+	return metric
+}
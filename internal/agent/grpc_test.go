@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/agent/config"
+	"github.com/ustkit/cmas/internal/agent/outbox"
+	"github.com/ustkit/cmas/internal/agent/retry"
+	pb "github.com/ustkit/cmas/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fakeMetricsServer struct {
+	pb.UnimplementedMetricsServer
+
+	codes    []codes.Code
+	calls    int
+	received []*pb.Metric
+}
+
+func (s *fakeMetricsServer) UpdateBatch(ctx context.Context, req *pb.UpdateBatchRequest) (*pb.UpdateBatchResponse, error) {
+	code := s.codes[s.calls]
+	s.calls++
+
+	if code != codes.OK {
+		return nil, status.Error(code, code.String())
+	}
+
+	s.received = req.GetMetrics()
+
+	return &pb.UpdateBatchResponse{}, nil
+}
+
+func newFakeMetricsClient(t *testing.T, server *fakeMetricsServer) pb.MetricsClient {
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	pb.RegisterMetricsServer(grpcServer, server)
+
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewMetricsClient(conn)
+}
+
+func TestSendGRPC(t *testing.T) {
+	tests := []struct {
+		name         string
+		codes        []codes.Code
+		wantCalls    int
+		wantErr      bool
+		wantQueue    int
+		wantReceived int
+	}{
+		{
+			name:         "case 1",
+			codes:        []codes.Code{codes.OK},
+			wantCalls:    1,
+			wantReceived: 2,
+		},
+		{
+			name:         "case 2",
+			codes:        []codes.Code{codes.Unavailable, codes.ResourceExhausted, codes.OK},
+			wantCalls:    3,
+			wantReceived: 2,
+		},
+		{
+			name:      "case 3",
+			codes:     []codes.Code{codes.InvalidArgument, codes.OK},
+			wantCalls: 1,
+		},
+		{
+			name:      "case 4",
+			codes:     []codes.Code{codes.Unavailable, codes.Unavailable, codes.Unavailable},
+			wantCalls: 3,
+			wantErr:   true,
+			wantQueue: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &fakeMetricsServer{codes: tt.codes}
+			client := newFakeMetricsClient(t, server)
+
+			box, err := outbox.New(t.TempDir(), 0, 0)
+			require.NoError(t, err)
+
+			metrics := NewMetrics()
+			metrics.SetOutbox(box)
+			metrics.SetRetryPolicy(retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+			err = metrics.SendGRPC(context.Background(), client, &config.Config{Key: "secret"})
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantCalls, server.calls)
+
+			n, err := box.Len()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantQueue, n)
+
+			require.Len(t, server.received, tt.wantReceived)
+			for _, metric := range server.received {
+				assert.NotEmpty(t, metric.GetHash())
+			}
+		})
+	}
+}
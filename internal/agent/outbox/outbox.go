@@ -0,0 +1,196 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ext = ".batch"
+
+// ErrDrop tells Replay that a batch can never be delivered (for example the
+// server rejected it as malformed) and must be removed instead of retried.
+var ErrDrop = errors.New("drop batch")
+
+// Outbox is a persistent FIFO of encoded batches. Each batch is a file in
+// dir named after its enqueue time and a sequence number, so the order and
+// age survive restarts. The queue is bounded by the total size of the files
+// and the age of the oldest one; the oldest batches are evicted first. The
+// newest batch is never evicted for size, so a batch larger than the limit
+// is still delivered rather than lost.
+type Outbox struct {
+	mu      *sync.Mutex
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	seq     uint64
+	now     func() time.Time
+}
+
+type entry struct {
+	name    string
+	created time.Time
+	size    int64
+}
+
+func New(dir string, maxSize int64, maxAge time.Duration) (*Outbox, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Outbox{
+		mu:      &sync.Mutex{},
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		now:     time.Now,
+	}, nil
+}
+
+func (o *Outbox) Append(batch []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.seq++
+	name := fmt.Sprintf("%020d-%010d%s", o.now().UnixNano(), o.seq, ext)
+	tmp := filepath.Join(o.dir, "."+name+".tmp")
+
+	err := os.WriteFile(tmp, batch, 0o600)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, filepath.Join(o.dir, name))
+	if err != nil {
+		os.Remove(tmp)
+
+		return err
+	}
+
+	_, err = o.evict()
+
+	return err
+}
+
+// Replay passes queued batches to send from oldest to newest and removes
+// each one that was sent. It stops at the first error other than ErrDrop
+// and returns it, leaving that batch and the newer ones queued.
+func (o *Outbox) Replay(send func(batch []byte) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries, err := o.evict()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(o.dir, e.name)
+
+		batch, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		err = send(batch)
+		if err != nil && !errors.Is(err, ErrDrop) {
+			return err
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *Outbox) Len() (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries, err := o.list()
+
+	return len(entries), err
+}
+
+// evict removes expired batches and the oldest ones above the size limit,
+// except the newest, and returns what is left, oldest first.
+func (o *Outbox) evict() ([]entry, error) {
+	entries, err := o.list()
+	if err != nil {
+		return nil, err
+	}
+
+	total := int64(0)
+	for _, e := range entries {
+		total += e.size
+	}
+
+	for len(entries) > 0 {
+		e := entries[0]
+		expired := o.maxAge > 0 && o.now().Sub(e.created) > o.maxAge
+		oversized := o.maxSize > 0 && total > o.maxSize && len(entries) > 1
+
+		if !expired && !oversized {
+			break
+		}
+
+		err = os.Remove(filepath.Join(o.dir, e.name))
+		if err != nil {
+			return nil, err
+		}
+
+		total -= e.size
+		entries = entries[1:]
+	}
+
+	return entries, nil
+}
+
+func (o *Outbox) list() ([]entry, error) {
+	files, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]entry, 0, len(files))
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, ext) || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		stamp, _, ok := strings.Cut(name, "-")
+		if !ok {
+			continue
+		}
+
+		nanos, err := strconv.ParseInt(stamp, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry{name: name, created: time.Unix(0, nanos), size: info.Size()})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	return entries, nil
+}
@@ -0,0 +1,123 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func replayAll(t *testing.T, o *Outbox) []string {
+	t.Helper()
+
+	sent := []string{}
+	err := o.Replay(func(batch []byte) error {
+		sent = append(sent, string(batch))
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	return sent
+}
+
+func TestOutbox_ReplayInOrder(t *testing.T) {
+	o, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, o.Append([]byte(fmt.Sprintf("batch %d", i))))
+	}
+
+	errDown := errors.New("server down")
+	calls := 0
+	err = o.Replay(func(batch []byte) error {
+		calls++
+		if calls == 2 {
+			return errDown
+		}
+
+		return nil
+	})
+	assert.ErrorIs(t, err, errDown)
+
+	n, err := o.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	assert.Equal(t, []string{"batch 1", "batch 2"}, replayAll(t, o))
+
+	n, err = o.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestOutbox_Drop(t *testing.T) {
+	o, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, o.Append([]byte("bad")))
+	require.NoError(t, o.Append([]byte("good")))
+
+	sent := []string{}
+	err = o.Replay(func(batch []byte) error {
+		if string(batch) == "bad" {
+			return fmt.Errorf("%w: rejected", ErrDrop)
+		}
+
+		sent = append(sent, string(batch))
+
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"good"}, sent)
+}
+
+func TestOutbox_Limits(t *testing.T) {
+	dir := t.TempDir()
+
+	o, err := New(dir, 10, time.Hour)
+	require.NoError(t, err)
+
+	now := time.Now()
+	o.now = func() time.Time { return now }
+
+	require.NoError(t, o.Append([]byte("aaaa")))
+	require.NoError(t, o.Append([]byte("bbbb")))
+	require.NoError(t, o.Append([]byte("cccc")))
+
+	n, err := o.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	now = now.Add(2 * time.Hour)
+	require.NoError(t, o.Append([]byte("dddd")))
+
+	assert.Equal(t, []string{"dddd"}, replayAll(t, o))
+}
+
+func TestOutbox_OversizedBatch(t *testing.T) {
+	o, err := New(t.TempDir(), 10, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, o.Append([]byte("aaaa")))
+	require.NoError(t, o.Append([]byte("larger than the limit")))
+
+	assert.Equal(t, []string{"larger than the limit"}, replayAll(t, o))
+}
+
+func TestOutbox_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	o, err := New(dir, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, o.Append([]byte("first")))
+	require.NoError(t, o.Append([]byte("second")))
+
+	o, err = New(dir, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, o.Append([]byte("third")))
+
+	assert.Equal(t, []string{"first", "second", "third"}, replayAll(t, o))
+}
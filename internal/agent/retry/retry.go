@@ -0,0 +1,90 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Policy retries operations that fail with temporary errors using
+// exponential backoff with jitter. An error is retried when it (or an error
+// it wraps) has a Temporary() bool method returning true; a RetryAfter()
+// time.Duration method overrides the computed delay.
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+type temporary interface {
+	Temporary() bool
+}
+
+type retryAfter interface {
+	RetryAfter() time.Duration
+}
+
+// Do calls fn until it succeeds, fails with a permanent error or runs out of
+// attempts. Retries never outlive ctx: when the next delay would pass the
+// context deadline, the last error is returned right away.
+func (p Policy) Do(ctx context.Context, fn func() error) error {
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= p.MaxAttempts || !isTemporary(err) {
+			return err
+		}
+
+		delay := p.delay(attempt, err)
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+
+			return err
+		}
+	}
+}
+
+func (p Policy) delay(attempt int, err error) time.Duration {
+	var after retryAfter
+	if errors.As(err, &after) && after.RetryAfter() > 0 {
+		return after.RetryAfter()
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		//nolint
+		delay += time.Duration(float64(delay) * p.Jitter * (2*rand.Float64() - 1))
+	}
+
+	if delay < 0 {
+		return 0
+	}
+
+	return delay
+}
+
+func isTemporary(err error) bool {
+	var t temporary
+
+	return errors.As(err, &t) && t.Temporary()
+}
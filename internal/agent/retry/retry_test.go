@@ -0,0 +1,117 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testError struct {
+	temporary bool
+	after     time.Duration
+}
+
+func (e testError) Error() string {
+	return "test error"
+}
+
+func (e testError) Temporary() bool {
+	return e.temporary
+}
+
+func (e testError) RetryAfter() time.Duration {
+	return e.after
+}
+
+func TestPolicy_Do(t *testing.T) {
+	policy := Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond, Jitter: 0.5}
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "case 1",
+			errs:      []error{nil},
+			wantCalls: 1,
+		},
+		{
+			name:      "case 2",
+			errs:      []error{testError{temporary: true}, fmt.Errorf("wrapped: %w", testError{temporary: true}), nil},
+			wantCalls: 3,
+		},
+		{
+			name:      "case 3",
+			errs:      []error{testError{temporary: false}, nil},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "case 4",
+			errs:      []error{errors.New("plain"), nil},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "case 5",
+			errs:      []error{testError{temporary: true}, testError{temporary: true}, testError{temporary: true}, nil},
+			wantCalls: 3,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := policy.Do(context.Background(), func() error {
+				calls++
+
+				return tt.errs[calls-1]
+			})
+
+			assert.Equal(t, tt.wantCalls, calls)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestPolicy_DoRespectsDeadline(t *testing.T) {
+	policy := Policy{MaxAttempts: 5, BaseDelay: time.Second}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	start := time.Now()
+	err := policy.Do(ctx, func() error {
+		calls++
+
+		return testError{temporary: true}
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestPolicy_Delay(t *testing.T) {
+	policy := Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	assert.Equal(t, 100*time.Millisecond, policy.delay(1, testError{}))
+	assert.Equal(t, 400*time.Millisecond, policy.delay(3, testError{}))
+	assert.Equal(t, time.Second, policy.delay(10, testError{}))
+	assert.Equal(t, 3*time.Second, policy.delay(1, testError{after: 3 * time.Second}))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.delay(2, testError{})
+		assert.GreaterOrEqual(t, delay, 100*time.Millisecond)
+		assert.LessOrEqual(t, delay, 300*time.Millisecond)
+	}
+}
@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"context"
+	"sync"
+)
+
+type job struct {
+	ctx  context.Context
+	send func(ctx context.Context) error
+	done func(err error)
+}
+
+// run skips the request when its report was cancelled while queued.
+func (j job) run() {
+	if err := j.ctx.Err(); err != nil {
+		j.done(err)
+
+		return
+	}
+
+	j.done(j.send(j.ctx))
+}
+
+// StartWorkers starts n workers executing the requests queued by Send. The
+// queue holds n jobs, so at most 2n requests are pending at any time. The
+// returned function stops the workers after the queue is drained; it must
+// not be called while Send is running.
+func (metrics *Metrics) StartWorkers(n int) (stop func()) {
+	if n < 1 {
+		n = 1
+	}
+
+	jobs := make(chan job, n)
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range jobs {
+				j.run()
+			}
+		}()
+	}
+
+	metrics.mu.Lock()
+	metrics.jobs = jobs
+	metrics.mu.Unlock()
+
+	return func() {
+		metrics.mu.Lock()
+		metrics.jobs = nil
+		metrics.mu.Unlock()
+
+		close(jobs)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,369 @@
+package configloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	errFormat  = errors.New("unsupported config file format")
+	errPair    = errors.New("invalid name=value pair")
+	errInteger = errors.New("not an integer")
+)
+
+// Load fills cfg, which already holds the defaults, from a config file, the
+// environment and the command line, each one overriding the previous one.
+// The file is chosen by the -c flag or the CONFIG variable. bind registers
+// the flags for a config value; Load calls it twice so that only the flags
+// given on the command line override the file and the environment.
+func Load[T any](cfg *T, name string, args []string, bind func(fs *flag.FlagSet, cfg *T)) error {
+	var path string
+
+	scratch := *cfg
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&path, "c", os.Getenv("CONFIG"), "config file (JSON or YAML)")
+	bind(fs, &scratch)
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if path != "" {
+		err = LoadFile(path, cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = env.Parse(cfg)
+	if err != nil {
+		return err
+	}
+
+	overrides := flag.NewFlagSet(name, flag.ContinueOnError)
+	bind(overrides, cfg)
+
+	fs.Visit(func(f *flag.Flag) {
+		if err == nil && f.Name != "c" {
+			err = overrides.Set(f.Name, f.Value.String())
+		}
+	})
+
+	return err
+}
+
+// LoadFile decodes a JSON or YAML file into cfg. JSON is converted to YAML
+// first so both formats share the yaml tags and accept durations such as
+// "10s". Unknown keys and fractions given for integer settings are reported
+// as errors.
+func LoadFile(path string, cfg interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var raw interface{}
+
+		// Numbers are kept exact: through float64 large integers would
+		// lose precision.
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+
+		err = decoder.Decode(&raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		data, err = yaml.Marshal(jsonNumbers(raw))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".yaml", ".yml":
+	default:
+		return fmt.Errorf("%w: %s", errFormat, path)
+	}
+
+	// yaml.v3 silently truncates a float decoded into an integer.
+	var node yaml.Node
+
+	err = yaml.Unmarshal(data, &node)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	err = checkIntegers(&node, reflect.TypeOf(cfg))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(cfg)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// jsonNumbers replaces the json.Numbers in raw by int64 or float64 values.
+func jsonNumbers(raw interface{}) interface{} {
+	switch v := raw.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+
+		f, _ := v.Float64()
+
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = jsonNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonNumbers(item)
+		}
+	}
+
+	return raw
+}
+
+// checkIntegers reports the fractional numbers node holds for integer
+// fields of t.
+func checkIntegers(node *yaml.Node, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+
+		return checkIntegers(node.Content[0], t)
+	case yaml.AliasNode:
+		return checkIntegers(node.Alias, t)
+	case yaml.ScalarNode:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return nil
+		}
+
+		if t == reflect.TypeOf(time.Duration(0)) || node.ShortTag() != "!!float" {
+			return nil
+		}
+
+		f, err := strconv.ParseFloat(node.Value, 64)
+		if err != nil || f != math.Trunc(f) {
+			return fmt.Errorf("%w: line %d: %s", errInteger, node.Line, node.Value)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			var field reflect.Type
+
+			switch t.Kind() {
+			case reflect.Map:
+				field = t.Elem()
+			case reflect.Struct:
+				f, ok := fieldByYAMLName(t, node.Content[i].Value)
+				if !ok {
+					continue
+				}
+
+				field = f.Type
+			default:
+				return nil
+			}
+
+			err := checkIntegers(node.Content[i+1], field)
+			if err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return nil
+		}
+
+		for _, item := range node.Content {
+			err := checkIntegers(item, t.Elem())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// fieldByYAMLName finds the field of t that yaml.v3 decodes the key name
+// into: the yaml tag name or else the lower-cased field name.
+func fieldByYAMLName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if tag == "-" {
+			continue
+		}
+
+		if tag == "" {
+			tag = strings.ToLower(field.Name)
+		}
+
+		if tag == name {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// List is a flag.Value for comma-separated lists. Set replaces the whole
+// list, so a flag overrides the list from a file instead of extending it.
+type List []string
+
+func (l *List) String() string {
+	if l == nil {
+		return ""
+	}
+
+	return strings.Join(*l, ",")
+}
+
+func (l *List) Set(value string) error {
+	items := []string{}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	*l = items
+
+	return nil
+}
+
+func ListVar(fs *flag.FlagSet, p *[]string, name, usage string) {
+	fs.Var((*List)(p), name, usage)
+}
+
+// Map is a flag.Value and an env value for comma-separated name=value
+// pairs. Like List, Set replaces the whole map.
+type Map map[string]string
+
+func (m Map) String() string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+m[name])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (m *Map) Set(value string) error {
+	items := Map{}
+
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%w: %q", errPair, pair)
+		}
+
+		items[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	*m = items
+
+	return nil
+}
+
+func (m *Map) UnmarshalText(text []byte) error {
+	return m.Set(string(text))
+}
+
+// Diff lists the settings that differ between two configs of the same type
+// as "name: old -> new", named by their yaml paths. Values of fields tagged
+// secret:"true" are not printed.
+func Diff(old, new interface{}) []string {
+	return diff("", reflect.ValueOf(old), reflect.ValueOf(new), nil)
+}
+
+func diff(prefix string, old, new reflect.Value, changes []string) []string {
+	for old.Kind() == reflect.Ptr {
+		old, new = old.Elem(), new.Elem()
+	}
+
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		name = prefix + name
+		oldValue, newValue := old.Field(i), new.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			changes = diff(name+".", oldValue, newValue, changes)
+
+			continue
+		}
+
+		if reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+			continue
+		}
+
+		if field.Tag.Get("secret") == "true" {
+			changes = append(changes, name+" changed")
+
+			continue
+		}
+
+		changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, oldValue.Interface(), newValue.Interface()))
+	}
+
+	return changes
+}
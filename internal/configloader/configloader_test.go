@@ -0,0 +1,169 @@
+package configloader
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testNested struct {
+	Items []string `env:"ITEMS" yaml:"items"`
+}
+
+type testConfig struct {
+	Address  string        `env:"TEST_ADDRESS" yaml:"address"`
+	Interval time.Duration `env:"TEST_INTERVAL" yaml:"interval"`
+	Limit    int           `env:"TEST_LIMIT" yaml:"limit"`
+	Nested   testNested    `envPrefix:"TEST_NESTED_" yaml:"nested"`
+}
+
+func bindTest(fs *flag.FlagSet, c *testConfig) {
+	fs.StringVar(&c.Address, "a", c.Address, "address")
+	fs.DurationVar(&c.Interval, "i", c.Interval, "interval")
+	fs.IntVar(&c.Limit, "l", c.Limit, "limit")
+	ListVar(fs, &c.Nested.Items, "items", "items")
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	jsonFile := writeFile(t, "config.json", `{"address": "file:1", "interval": "5s", "limit": 2, "nested": {"items": ["a", "b"]}}`)
+	yamlFile := writeFile(t, "config.yaml", "address: file:1\ninterval: 5s\nnested:\n  items: [a, b]\n")
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want testConfig
+	}{
+		{
+			name: "case 1",
+			want: testConfig{Address: "default", Interval: time.Second, Limit: 1},
+		},
+		{
+			name: "case 2",
+			args: []string{"-c", jsonFile},
+			want: testConfig{Address: "file:1", Interval: 5 * time.Second, Limit: 2, Nested: testNested{Items: []string{"a", "b"}}},
+		},
+		{
+			name: "case 3",
+			env:  map[string]string{"CONFIG": yamlFile, "TEST_INTERVAL": "7s", "TEST_NESTED_ITEMS": "c,d"},
+			want: testConfig{Address: "file:1", Interval: 7 * time.Second, Limit: 1, Nested: testNested{Items: []string{"c", "d"}}},
+		},
+		{
+			name: "case 4",
+			args: []string{"-c", jsonFile, "-i", "9s", "-items", "e"},
+			env:  map[string]string{"TEST_INTERVAL": "7s", "TEST_ADDRESS": "env:1"},
+			want: testConfig{Address: "env:1", Interval: 9 * time.Second, Limit: 2, Nested: testNested{Items: []string{"e"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg := &testConfig{Address: "default", Interval: time.Second, Limit: 1}
+			require.NoError(t, Load(cfg, "test", tt.args, bindTest))
+			assert.Equal(t, tt.want, *cfg)
+		})
+	}
+}
+
+func TestLoadFile_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{name: "case 1", file: "config.json", data: `{"adress": "typo"}`},
+		{name: "case 2", file: "config.yaml", data: "interval: soon\n"},
+		{name: "case 3", file: "config.json", data: `{"address": `},
+		{name: "case 4", file: "config.toml", data: `address = "x"`},
+		{name: "case 5", file: "config.json", data: `{"limit": 1.7}`},
+		{name: "case 6", file: "config.yaml", data: "limit: 1.7\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &testConfig{}
+			assert.Error(t, LoadFile(writeFile(t, tt.file, tt.data), cfg))
+		})
+	}
+
+	assert.Error(t, LoadFile(filepath.Join(t.TempDir(), "missing.json"), &testConfig{}))
+}
+
+func TestLoadFile_Numbers(t *testing.T) {
+	type numbers struct {
+		Limit  int64   `yaml:"limit"`
+		Jitter float64 `yaml:"jitter"`
+	}
+
+	cfg := &numbers{}
+	require.NoError(t, LoadFile(writeFile(t, "config.json", `{"limit": 9007199254740993, "jitter": 0.5}`), cfg))
+	assert.Equal(t, numbers{Limit: 9007199254740993, Jitter: 0.5}, *cfg)
+
+	cfg = &numbers{}
+	require.NoError(t, LoadFile(writeFile(t, "config.json", `{"limit": 2.0}`), cfg))
+	assert.Equal(t, int64(2), cfg.Limit)
+}
+
+func TestDiff(t *testing.T) {
+	type secretConfig struct {
+		testConfig `yaml:"base"`
+		Key        string `yaml:"key" secret:"true"`
+	}
+
+	old := &secretConfig{testConfig: testConfig{Address: "a", Interval: time.Second}, Key: "one"}
+	new := &secretConfig{testConfig: testConfig{Address: "a", Interval: 2 * time.Second, Nested: testNested{Items: []string{"x"}}}, Key: "two"}
+
+	assert.Empty(t, Diff(old, old))
+	assert.Equal(t, []string{
+		"base.interval: 1s -> 2s",
+		"base.nested.items: [] -> [x]",
+		"key changed",
+	}, Diff(old, new))
+}
+
+func TestMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Map
+		wantErr bool
+	}{
+		{name: "case 1", value: "env=prod, dc = eu", want: Map{"env": "prod", "dc": "eu"}},
+		{name: "case 2", value: "", want: Map{}},
+		{name: "case 3", value: "env", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Map{"old": "1"}
+
+			err := m.UnmarshalText([]byte(tt.value))
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, m)
+		})
+	}
+
+	assert.Equal(t, "dc=eu,env=prod", Map{"env": "prod", "dc": "eu"}.String())
+}
@@ -0,0 +1,58 @@
+package encryption
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	privatePEM, publicPEM, err := GenerateKeys(2048)
+	require.NoError(t, err)
+
+	privateKey, err := ParsePrivateKey(privatePEM)
+	require.NoError(t, err)
+	publicKey, err := ParsePublicKey(publicPEM)
+	require.NoError(t, err)
+
+	plaintext := []byte(`[{"id":"Alloc","type":"gauge","value":3459}]`)
+
+	message, err := Encrypt(publicKey, plaintext)
+	require.NoError(t, err)
+	assert.NotContains(t, string(message), "Alloc")
+
+	decrypted, err := Decrypt(privateKey, message)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	tests := []struct {
+		name    string
+		message []byte
+	}{
+		{name: "case 1", message: message[:10]},
+		{name: "case 2", message: message[:privateKey.Size()+4]},
+		{name: "case 3", message: append(append([]byte{}, message[:len(message)-1]...), message[len(message)-1]^1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decrypt(privateKey, tt.message)
+			assert.ErrorIs(t, err, ErrInvalidMessage)
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	_, err := ParsePublicKey([]byte("garbage"))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	privatePEM, publicPEM, err := GenerateKeys(2048)
+	require.NoError(t, err)
+
+	_, err = ParsePublicKey(privatePEM)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = ParsePrivateKey(publicPEM)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
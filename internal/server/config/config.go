@@ -1,10 +1,118 @@
 package config
 
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ustkit/cmas/internal/configloader"
+)
+
+var ErrInvalid = errors.New("invalid server config")
+
 type Config struct {
-	Address       string `env:"ADDRESS"`
-	StoreInterval string `env:"STORE_INTERVAL"`
-	StoreFile     string `env:"STORE_FILE"`
-	Restore       bool   `env:"RESTORE"`
-	Key           string `env:"KEY"`
-	DataBaseDSN   string `env:"DATABASE_DSN"`
+	Address         string        `env:"ADDRESS" yaml:"address"`
+	GRPCAddress     string        `env:"GRPC_ADDRESS" yaml:"grpc_address"`
+	StoreInterval   time.Duration `env:"STORE_INTERVAL" yaml:"store_interval"`
+	StoreFile       string        `env:"STORE_FILE" yaml:"store_file"`
+	Restore         bool          `env:"RESTORE" yaml:"restore"`
+	Key             string        `env:"KEY" yaml:"key" secret:"true"`
+	DataBaseDSN     string        `env:"DATABASE_DSN" yaml:"database_dsn" secret:"true"`
+	MaxBodySize     int64         `env:"MAX_BODY_SIZE" yaml:"max_body_size"`
+	CryptoKey       string        `env:"CRYPTO_KEY" yaml:"crypto_key"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" yaml:"shutdown_timeout"`
+	BatchTTL        time.Duration `env:"BATCH_TTL" yaml:"batch_ttl"`
+
+	TLS     TLS     `envPrefix:"TLS_" yaml:"tls"`
+	History History `envPrefix:"HISTORY_" yaml:"history"`
+}
+
+type TLS struct {
+	Cert     string `env:"CERT" yaml:"cert"`
+	Key      string `env:"KEY" yaml:"key"`
+	ClientCA string `env:"CLIENT_CA" yaml:"client_ca"`
+}
+
+// History controls the samples kept per series. Retention 0, the default,
+// disables history; Samples caps each series in memory, PostgreSQL drops
+// whole days.
+type History struct {
+	Retention time.Duration `env:"RETENTION" yaml:"retention"`
+	Samples   int           `env:"SAMPLES" yaml:"samples"`
+}
+
+func Default() *Config {
+	return &Config{
+		Address:         "localhost:8080",
+		StoreInterval:   300 * time.Second,
+		StoreFile:       "/tmp/cmas-metrics-db.json",
+		Restore:         true,
+		MaxBodySize:     10 << 20,
+		ShutdownTimeout: 5 * time.Second,
+		BatchTTL:        24 * time.Hour,
+		History:         History{Samples: 8640},
+	}
+}
+
+// Load builds the server config from the defaults, the config file (-c or
+// CONFIG), the environment and args, in increasing priority, and validates
+// the result.
+func Load(args []string) (*Config, error) {
+	serverConfig := Default()
+
+	err := configloader.Load(serverConfig, "server", args, bind)
+	if err != nil {
+		return nil, err
+	}
+
+	return serverConfig, serverConfig.Validate()
+}
+
+func bind(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.Address, "a", c.Address, "server address")
+	fs.BoolVar(&c.Restore, "r", c.Restore, "restore data")
+	fs.DurationVar(&c.StoreInterval, "i", c.StoreInterval, "store interval, 0 stores on every update")
+	fs.StringVar(&c.StoreFile, "f", c.StoreFile, "store file")
+	fs.StringVar(&c.Key, "k", c.Key, "key")
+	fs.StringVar(&c.DataBaseDSN, "d", c.DataBaseDSN, "database dsn")
+	fs.StringVar(&c.GRPCAddress, "g", c.GRPCAddress, "gRPC server address, empty disables gRPC")
+	fs.StringVar(&c.TLS.Cert, "tls-cert", c.TLS.Cert, "TLS certificate file, enables HTTPS")
+	fs.StringVar(&c.TLS.Key, "tls-key", c.TLS.Key, "TLS private key file")
+	fs.StringVar(&c.TLS.ClientCA, "tls-client-ca", c.TLS.ClientCA, "CA bundle for verifying agent certificates (mTLS)")
+	fs.StringVar(&c.CryptoKey, "crypto-key", c.CryptoKey, "private key file for decrypting agent reports")
+	fs.Int64Var(&c.MaxBodySize, "max-body-size", c.MaxBodySize, "max decompressed request body size in bytes")
+	fs.DurationVar(&c.History.Retention, "history-retention", c.History.Retention, "how long samples are kept, 0 disables history")
+	fs.IntVar(&c.History.Samples, "history-samples", c.History.Samples, "max samples kept per series in memory")
+	fs.DurationVar(&c.BatchTTL, "batch-ttl", c.BatchTTL, "how long applied batch IDs are remembered to skip resent batches")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "time limit for in-flight requests on shutdown")
+}
+
+func (c *Config) Validate() error {
+	switch {
+	case c.Address == "":
+		return fmt.Errorf("%w: address is empty", ErrInvalid)
+	case c.StoreInterval < 0:
+		return fmt.Errorf("%w: store_interval must not be negative, got %s", ErrInvalid, c.StoreInterval)
+	case c.MaxBodySize < 0:
+		return fmt.Errorf("%w: max_body_size must not be negative, got %d", ErrInvalid, c.MaxBodySize)
+	case c.ShutdownTimeout <= 0:
+		return fmt.Errorf("%w: shutdown_timeout must be positive, got %s", ErrInvalid, c.ShutdownTimeout)
+	case c.BatchTTL <= 0:
+		return fmt.Errorf("%w: batch_ttl must be positive, got %s", ErrInvalid, c.BatchTTL)
+	case c.History.Retention < 0:
+		return fmt.Errorf("%w: history.retention must not be negative, got %s", ErrInvalid, c.History.Retention)
+	case c.History.Retention > 0 && c.History.Samples < 1:
+		return fmt.Errorf("%w: history.samples must be positive, got %d", ErrInvalid, c.History.Samples)
+	case (c.TLS.Cert == "") != (c.TLS.Key == ""):
+		return fmt.Errorf("%w: tls.cert and tls.key must be set together", ErrInvalid)
+	case c.TLS.ClientCA != "" && c.TLS.Cert == "":
+		return fmt.Errorf("%w: tls.client_ca requires tls.cert", ErrInvalid)
+	case c.GRPCAddress != "" && c.CryptoKey != "" && c.TLS.Cert == "":
+		// gRPC batches are not encrypted with crypto_key, so without TLS
+		// the gRPC port would take the plain text the HTTP routes reject.
+		return fmt.Errorf("%w: grpc_address with crypto_key requires tls.cert", ErrInvalid)
+	}
+
+	return nil
 }
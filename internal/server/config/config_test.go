@@ -1,9 +1,75 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestServerConfig(t *testing.T) {
 	t.Skip()
 }
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"address": ":9090",
+		"store_interval": "0s",
+		"restore": false,
+		"tls": {"cert": "server.crt", "key": "server.key"}
+	}`), 0o600))
+
+	t.Setenv("CONFIG", path)
+	t.Setenv("ADDRESS", ":9091")
+
+	serverConfig, err := Load([]string{"-r"})
+	require.NoError(t, err)
+
+	assert.Equal(t, ":9091", serverConfig.Address)
+	assert.Equal(t, time.Duration(0), serverConfig.StoreInterval)
+	assert.True(t, serverConfig.Restore)
+	assert.Equal(t, TLS{Cert: "server.crt", Key: "server.key"}, serverConfig.TLS)
+	assert.Equal(t, "/tmp/cmas-metrics-db.json", serverConfig.StoreFile)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr bool
+	}{
+		{name: "case 1", modify: func(c *Config) {}},
+		{name: "case 2", modify: func(c *Config) { c.Address = "" }, wantErr: true},
+		{name: "case 3", modify: func(c *Config) { c.StoreInterval = -time.Second }, wantErr: true},
+		{name: "case 4", modify: func(c *Config) { c.TLS.ClientCA = "ca.pem" }, wantErr: true},
+		{name: "case 5", modify: func(c *Config) { c.TLS.Key = "server.key" }, wantErr: true},
+		{name: "case 6", modify: func(c *Config) { c.ShutdownTimeout = 0 }, wantErr: true},
+		{name: "case 7", modify: func(c *Config) { c.BatchTTL = 0 }, wantErr: true},
+		{name: "case 8", modify: func(c *Config) { c.History = History{Retention: time.Hour} }, wantErr: true},
+		{name: "case 9", modify: func(c *Config) { c.History = History{} }},
+		{name: "case 10", modify: func(c *Config) { c.History.Retention = time.Hour }},
+		{name: "case 11", modify: func(c *Config) { c.GRPCAddress, c.CryptoKey = ":3200", "private.pem" }, wantErr: true},
+		{name: "case 12", modify: func(c *Config) {
+			c.GRPCAddress, c.CryptoKey = ":3200", "private.pem"
+			c.TLS = TLS{Cert: "server.crt", Key: "server.key"}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig := Default()
+			tt.modify(serverConfig)
+
+			err := serverConfig.Validate()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalid)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
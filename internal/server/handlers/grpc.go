@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	pb "github.com/ustkit/cmas/internal/proto"
+	"github.com/ustkit/cmas/internal/server/config"
+	"github.com/ustkit/cmas/internal/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCHandler serves the Metrics gRPC service on top of the same repository
+// and with the same validation rules as the JSON handlers.
+type GRPCHandler struct {
+	pb.UnimplementedMetricsServer
+
+	mu         *sync.RWMutex
+	config     *config.Config
+	repository types.MetricRepo
+}
+
+func NewGRPCHandler(serverConfig *config.Config, repo types.MetricRepo) *GRPCHandler {
+	return &GRPCHandler{mu: &sync.RWMutex{}, config: serverConfig, repository: repo}
+}
+
+// SetConfig swaps the config used by later calls, so a reload does not have
+// to restart the gRPC server.
+func (h *GRPCHandler) SetConfig(serverConfig *config.Config) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.config = serverConfig
+}
+
+func (h *GRPCHandler) key() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.config.Key
+}
+
+func (h *GRPCHandler) UpdateBatch(ctx context.Context, req *pb.UpdateBatchRequest) (*pb.UpdateBatchResponse, error) {
+	err := h.save(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.UpdateBatchResponse{}, nil
+}
+
+func (h *GRPCHandler) StreamUpdates(stream pb.Metrics_StreamUpdatesServer) error {
+	response := &pb.StreamUpdatesResponse{}
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(response)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = h.save(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+
+		response.Batches++
+		response.Metrics += int64(len(req.GetMetrics()))
+	}
+}
+
+func (h *GRPCHandler) GetValue(ctx context.Context, req *pb.GetValueRequest) (*pb.GetValueResponse, error) {
+	mType := protoToType(req.GetType())
+
+	labels := types.Labels(req.GetLabels())
+	if err := labels.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	value, err := h.repository.FindByName(ctx, req.GetId(), labels)
+	if err != nil || value.TValue != mType {
+		return nil, status.Error(codes.NotFound, "metric not found")
+	}
+
+	return &pb.GetValueResponse{Metric: h.metricToProto(req.GetId(), value)}, nil
+}
+
+func (h *GRPCHandler) ListMetrics(ctx context.Context, req *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	filter := types.Labels(req.GetLabels())
+	if err := filter.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	values, err := h.repository.FindAll(ctx, filter)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	response := &pb.ListMetricsResponse{Metrics: make([]*pb.Metric, 0, len(keys))}
+	for _, key := range keys {
+		response.Metrics = append(response.Metrics, h.metricToProto(types.SeriesID(key), *values[key]))
+	}
+
+	return response, nil
+}
+
+func (h *GRPCHandler) save(ctx context.Context, req *pb.UpdateBatchRequest) error {
+	batch := types.Batch{
+		Agent:  req.GetAgentId(),
+		ID:     req.GetBatchId(),
+		Values: make([]types.ValueJSON, 0, len(req.GetMetrics())),
+	}
+
+	for _, metric := range req.GetMetrics() {
+		valueJSON, err := h.metricFromProto(metric)
+		if err != nil {
+			return err
+		}
+
+		batch.Values = append(batch.Values, valueJSON)
+	}
+
+	_, err := h.repository.SaveBatch(ctx, batch)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	return nil
+}
+
+func (h *GRPCHandler) metricFromProto(metric *pb.Metric) (types.ValueJSON, error) {
+	if strings.TrimSpace(metric.GetId()) == "" {
+		return types.ValueJSON{}, status.Error(codes.InvalidArgument, "metric name empity")
+	}
+
+	valueJSON := types.ValueJSON{ID: metric.GetId(), MType: protoToType(metric.GetType()), Hash: metric.GetHash()}
+
+	if len(metric.GetLabels()) > 0 {
+		valueJSON.Labels = metric.GetLabels()
+
+		if err := valueJSON.Labels.Validate(); err != nil {
+			return types.ValueJSON{}, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	switch valueJSON.MType {
+	case GAUGE:
+		value := types.Gauge(metric.GetValue())
+		valueJSON.Value = &value
+	case COUNTER:
+		delta := types.Counter(metric.GetDelta())
+		valueJSON.Delta = &delta
+	default:
+		return types.ValueJSON{}, status.Errorf(codes.Unimplemented, "unknown data type for %s", metric.GetId())
+	}
+
+	if key := h.key(); key != "" && !checkHash(valueJSON, key) {
+		return types.ValueJSON{}, status.Errorf(codes.InvalidArgument, "unknown or bad hash value for %s", metric.GetId())
+	}
+
+	return valueJSON, nil
+}
+
+func (h *GRPCHandler) metricToProto(name string, value types.Value) *pb.Metric {
+	metric := &pb.Metric{Id: name, Labels: value.Labels}
+	valueJSON := types.ValueJSON{ID: name, MType: value.TValue, Labels: value.Labels}
+
+	switch value.TValue {
+	case GAUGE:
+		metric.Type = pb.Metric_GAUGE
+		metric.Value = float64(value.GValue)
+		valueJSON.Value = &value.GValue
+	case COUNTER:
+		metric.Type = pb.Metric_COUNTER
+		metric.Delta = int64(value.CValue)
+		valueJSON.Delta = &value.CValue
+	}
+
+	if key := h.key(); key != "" {
+		metric.Hash = calcHash(valueJSON, key)
+	}
+
+	return metric
+}
+
+func protoToType(mType pb.Metric_MType) string {
+	switch mType {
+	case pb.Metric_GAUGE:
+		return GAUGE
+	case pb.Metric_COUNTER:
+		return COUNTER
+	}
+
+	return ""
+}
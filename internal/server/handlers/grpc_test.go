@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pb "github.com/ustkit/cmas/internal/proto"
+	"github.com/ustkit/cmas/internal/server/repositories"
+	"github.com/ustkit/cmas/internal/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newGRPCClient(t *testing.T, key string) pb.MetricsClient {
+	serverConfig := getConfig()
+	serverConfig.Key = key
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	pb.RegisterMetricsServer(server, NewGRPCHandler(serverConfig, repositories.NewRepositoryInMemory(serverConfig)))
+
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewMetricsClient(conn)
+}
+
+func TestGRPCHandler_UpdateBatch(t *testing.T) {
+	gauge := types.Gauge(1)
+
+	tests := []struct {
+		name     string
+		key      string
+		metrics  []*pb.Metric
+		wantCode codes.Code
+	}{
+		{
+			name: "case 1",
+			metrics: []*pb.Metric{
+				{Id: "Alloc", Type: pb.Metric_GAUGE, Value: 3459},
+				{Id: "PollCount", Type: pb.Metric_COUNTER, Delta: 10},
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name:     "case 2",
+			metrics:  []*pb.Metric{{Id: " ", Type: pb.Metric_GAUGE, Value: 1}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "case 3",
+			metrics:  []*pb.Metric{{Id: "Alloc"}},
+			wantCode: codes.Unimplemented,
+		},
+		{
+			name:     "case 4",
+			key:      "secret",
+			metrics:  []*pb.Metric{{Id: "Alloc", Type: pb.Metric_GAUGE, Value: 1, Hash: "00"}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "case 5",
+			key:  "secret",
+			metrics: []*pb.Metric{{
+				Id:    "Alloc",
+				Type:  pb.Metric_GAUGE,
+				Value: 1,
+				Hash:  calcHash(types.ValueJSON{ID: "Alloc", MType: GAUGE, Value: &gauge}, "secret"),
+			}},
+			wantCode: codes.OK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newGRPCClient(t, tt.key)
+
+			_, err := client.UpdateBatch(context.Background(), &pb.UpdateBatchRequest{Metrics: tt.metrics})
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+}
+
+func TestGRPCHandler_Values(t *testing.T) {
+	ctx := context.Background()
+	client := newGRPCClient(t, "secret")
+
+	gauge := types.Gauge(3459)
+	delta := types.Counter(5)
+	metrics := []*pb.Metric{
+		{Id: "Alloc", Type: pb.Metric_GAUGE, Value: 3459, Hash: calcHash(types.ValueJSON{ID: "Alloc", MType: GAUGE, Value: &gauge}, "secret")},
+		{Id: "PollCount", Type: pb.Metric_COUNTER, Delta: 5, Hash: calcHash(types.ValueJSON{ID: "PollCount", MType: COUNTER, Delta: &delta}, "secret")},
+	}
+
+	stream, err := client.StreamUpdates(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.UpdateBatchRequest{Metrics: metrics}))
+	require.NoError(t, stream.Send(&pb.UpdateBatchRequest{Metrics: metrics[1:]}))
+
+	totals, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), totals.GetBatches())
+	assert.Equal(t, int64(3), totals.GetMetrics())
+
+	value, err := client.GetValue(ctx, &pb.GetValueRequest{Id: "PollCount", Type: pb.Metric_COUNTER})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), value.GetMetric().GetDelta())
+	assert.NotEmpty(t, value.GetMetric().GetHash())
+
+	_, err = client.GetValue(ctx, &pb.GetValueRequest{Id: "PollCount", Type: pb.Metric_GAUGE})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	list, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetMetrics(), 2)
+	assert.Equal(t, "Alloc", list.GetMetrics()[0].GetId())
+	assert.Equal(t, 3459.0, list.GetMetrics()[0].GetValue())
+}
+
+func TestGRPCHandler_UpdateBatchOnce(t *testing.T) {
+	client := newGRPCClient(t, "")
+	ctx := context.Background()
+	metrics := []*pb.Metric{{Id: "PollCount", Type: pb.Metric_COUNTER, Delta: 10}}
+
+	for _, batchID := range []string{"1", "1", "2"} {
+		_, err := client.UpdateBatch(ctx, &pb.UpdateBatchRequest{Metrics: metrics, BatchId: batchID, AgentId: "agent"})
+		require.NoError(t, err)
+	}
+
+	resp, err := client.GetValue(ctx, &pb.GetValueRequest{Id: "PollCount", Type: pb.Metric_COUNTER})
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), resp.GetMetric().GetDelta())
+}
+
+func TestGRPCHandler_Labels(t *testing.T) {
+	client := newGRPCClient(t, "secret")
+	ctx := context.Background()
+
+	for _, host := range []string{"a", "b"} {
+		metric := &pb.Metric{Id: "Alloc", Type: pb.Metric_GAUGE, Value: 1, Labels: map[string]string{"host": host}}
+		value := types.Gauge(1)
+		metric.Hash = calcHash(types.ValueJSON{ID: "Alloc", MType: GAUGE, Value: &value, Labels: metric.Labels}, "secret")
+
+		_, err := client.UpdateBatch(ctx, &pb.UpdateBatchRequest{Metrics: []*pb.Metric{metric}})
+		require.NoError(t, err)
+	}
+
+	list, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{Labels: map[string]string{"host": "b"}})
+	require.NoError(t, err)
+	require.Len(t, list.GetMetrics(), 1)
+	assert.Equal(t, "Alloc", list.GetMetrics()[0].GetId())
+	assert.Equal(t, map[string]string{"host": "b"}, list.GetMetrics()[0].GetLabels())
+
+	_, err = client.GetValue(ctx, &pb.GetValueRequest{Id: "Alloc", Type: pb.Metric_GAUGE})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	resp, err := client.GetValue(ctx, &pb.GetValueRequest{Id: "Alloc", Type: pb.Metric_GAUGE, Labels: map[string]string{"host": "a"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, resp.GetMetric().GetValue())
+
+	// The labels are signed, so moving a metric to another host breaks the hash.
+	metric := &pb.Metric{Id: "Alloc", Type: pb.Metric_GAUGE, Value: 1, Hash: list.GetMetrics()[0].GetHash(), Labels: map[string]string{"host": "c"}}
+	_, err = client.UpdateBatch(ctx, &pb.UpdateBatchRequest{Metrics: []*pb.Metric{metric}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
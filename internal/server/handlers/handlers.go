@@ -6,10 +6,15 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/ustkit/cmas/internal/server/config"
@@ -21,6 +26,13 @@ const (
 	COUNTER = "counter"
 )
 
+// MaxRangePoints caps the steps of a range query per series and
+// MaxRangeTotal the points of all its series together.
+const (
+	MaxRangePoints = 11000
+	MaxRangeTotal  = 10 * MaxRangePoints
+)
+
 type Handler struct {
 	config     *config.Config
 	repository types.MetricRepo
@@ -42,7 +54,14 @@ func (h *Handler) Index(w http.ResponseWriter, r *http.Request) {
 	<body>
 	<pre>`)
 
-	metrics, err := h.repository.FindAll(r.Context())
+	filter, err := queryLabels(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	metrics, err := h.repository.FindAll(r.Context(), filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNoContent)
 
@@ -50,7 +69,9 @@ func (h *Handler) Index(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for name, value := range metrics {
-		result.WriteString(name)
+		// Label values come from agents and are not restricted, so the
+		// series keys must not reach the page as markup.
+		result.WriteString(html.EscapeString(name))
 		result.WriteString(" = ")
 
 		switch value.TValue {
@@ -78,6 +99,13 @@ func (h *Handler) UpdatePlain(w http.ResponseWriter, r *http.Request) {
 	mName := chi.URLParam(r, "name")
 	mValue := chi.URLParam(r, "value")
 
+	labels, err := queryLabels(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
 	switch mType {
 	case GAUGE:
 		value, err := strconv.ParseFloat(mValue, 64)
@@ -87,7 +115,7 @@ func (h *Handler) UpdatePlain(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = h.repository.Save(r.Context(), mName, types.Value{GValue: types.Gauge(value), TValue: "gauge"})
+		err = h.repository.Save(r.Context(), mName, types.Value{GValue: types.Gauge(value), TValue: "gauge", Labels: labels})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 
@@ -101,7 +129,7 @@ func (h *Handler) UpdatePlain(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = h.repository.Save(r.Context(), mName, types.Value{CValue: types.Counter(value), TValue: "counter"})
+		err = h.repository.Save(r.Context(), mName, types.Value{CValue: types.Counter(value), TValue: "counter", Labels: labels})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 
@@ -120,7 +148,14 @@ func (h *Handler) ValuePlain(w http.ResponseWriter, r *http.Request) {
 	mType := chi.URLParam(r, "type")
 	mName := chi.URLParam(r, "name")
 
-	value, err := h.repository.FindByName(r.Context(), mName)
+	labels, err := queryLabels(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	value, err := h.repository.FindByName(r.Context(), mName, labels)
 	if err != nil || value.TValue != mType {
 		http.Error(w, "", http.StatusNotFound)
 
@@ -162,6 +197,14 @@ func (h *Handler) UpdateJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = valueJSON.Labels.Validate()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "{\"error\":%q}\n", err)
+
+		return
+	}
+
 	if h.config.Key != "" && !checkHash(valueJSON, h.config.Key) {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintln(w, "{\"error\":\"unknown or bad hash value\"}")
@@ -178,7 +221,7 @@ func (h *Handler) UpdateJSON(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = h.repository.Save(r.Context(), valueJSON.ID, types.Value{GValue: *valueJSON.Value, TValue: "gauge"})
+		err = h.repository.Save(r.Context(), valueJSON.ID, types.Value{GValue: *valueJSON.Value, TValue: "gauge", Labels: valueJSON.Labels})
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w, "{\"error\":%q}\n", err)
@@ -193,7 +236,7 @@ func (h *Handler) UpdateJSON(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = h.repository.Save(r.Context(), valueJSON.ID, types.Value{CValue: *valueJSON.Delta, TValue: "counter"})
+		err = h.repository.Save(r.Context(), valueJSON.ID, types.Value{CValue: *valueJSON.Delta, TValue: "counter", Labels: valueJSON.Labels})
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w, "{\"error\":%q}\n", err)
@@ -232,6 +275,14 @@ func (h *Handler) UpdateJSONBatch(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		err = valueJSON.Labels.Validate()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "{\"error\":%q}\n", err)
+
+			return
+		}
+
 		if h.config.Key != "" && !checkHash(valueJSON, h.config.Key) {
 			w.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(w, "{\"error\":\"unknown or bad hash value for %s\"}\n", valueJSON.ID)
@@ -264,7 +315,12 @@ func (h *Handler) UpdateJSONBatch(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	err = h.repository.SaveAll(r.Context(), valuesJSON)
+	// A resent batch that was already applied is acknowledged as is.
+	_, err = h.repository.SaveBatch(r.Context(), types.Batch{
+		Agent:  r.Header.Get(types.AgentIDHeader),
+		ID:     r.Header.Get(types.BatchIDHeader),
+		Values: valuesJSON,
+	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "{\"error\":%q}\n", err)
@@ -289,7 +345,7 @@ func (h *Handler) ValueJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	value, err := h.repository.FindByName(r.Context(), valueJSON.ID)
+	value, err := h.repository.FindByName(r.Context(), valueJSON.ID, valueJSON.Labels)
 	if err != nil || value.TValue != valueJSON.MType {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "{\"error\":\"metric not found\"}\n")
@@ -304,6 +360,9 @@ func (h *Handler) ValueJSON(w http.ResponseWriter, r *http.Request) {
 		valueJSON.Delta = &value.CValue
 	}
 
+	// Name only lookups may resolve to a labeled series.
+	valueJSON.Labels = value.Labels
+
 	if h.config.Key != "" {
 		valueJSON.Hash = calcHash(valueJSON, h.config.Key)
 	}
@@ -342,6 +401,148 @@ func (h *Handler) Ping(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "{}")
 }
 
+// QueryRange serves GET /api/v1/query_range?name=&from=&to=&step=&agg=
+// with the remaining parameters as a label filter. Times are RFC 3339 or Unix
+// seconds, to defaults to now and agg to avg. The repository builds the
+// whole result, so MaxRangeTotal is what bounds its memory; the series are
+// then encoded one by one rather than into a second buffer.
+func (h *Handler) QueryRange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	query, err := parseRangeQuery(r)
+	if err == nil {
+		err = query.Validate(MaxRangePoints)
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "{\"error\":%q}\n", err)
+
+		return
+	}
+
+	series, err := h.repository.QueryRange(r.Context(), query)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, types.ErrInvalidQuery) {
+			status = http.StatusBadRequest
+		}
+
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "{\"error\":%q}\n", err)
+
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	fmt.Fprint(w, "[")
+
+	for i, s := range series {
+		if i > 0 {
+			fmt.Fprint(w, ",")
+		}
+
+		err = encoder.Encode(s)
+		if err != nil {
+			log.Printf("query range: %s", err)
+
+			return
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	fmt.Fprintln(w, "]")
+}
+
+func parseRangeQuery(r *http.Request) (types.RangeQuery, error) {
+	params := r.URL.Query()
+	query := types.RangeQuery{ID: params.Get("name"), Agg: params.Get("agg"), To: time.Now(), Limit: MaxRangeTotal}
+
+	if query.Agg == "" {
+		query.Agg = types.AggAvg
+	}
+
+	from, err := parseTime(params.Get("from"))
+	if err != nil {
+		return query, fmt.Errorf("%w: from: %s", types.ErrInvalidQuery, err)
+	}
+
+	query.From = from
+
+	if params.Get("to") != "" {
+		query.To, err = parseTime(params.Get("to"))
+		if err != nil {
+			return query, fmt.Errorf("%w: to: %s", types.ErrInvalidQuery, err)
+		}
+	}
+
+	query.Step, err = parseStep(params.Get("step"))
+	if err != nil {
+		return query, fmt.Errorf("%w: step: %s", types.ErrInvalidQuery, err)
+	}
+
+	query.Labels, err = queryLabels(r, "name", "from", "to", "step", "agg")
+
+	return query, err
+}
+
+// parseTime accepts RFC 3339 or Unix seconds.
+func parseTime(value string) (time.Time, error) {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err == nil {
+		if math.IsNaN(seconds) || math.Abs(seconds) >= math.MaxInt64 {
+			return time.Time{}, fmt.Errorf("%q is out of range", value)
+		}
+
+		whole, frac := math.Modf(seconds)
+
+		return time.Unix(int64(whole), int64(frac*1e9)), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseStep accepts a duration ("30s") or seconds.
+func parseStep(value string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err == nil {
+		if math.IsNaN(seconds) || math.Abs(seconds) > float64(math.MaxInt64/time.Second) {
+			return 0, fmt.Errorf("%q is out of range", value)
+		}
+
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// queryLabels reads labels from the query string, e.g. ?host=web1, leaving
+// out the skipped parameters.
+func queryLabels(r *http.Request, skip ...string) (types.Labels, error) {
+	query := r.URL.Query()
+	for _, name := range skip {
+		query.Del(name)
+	}
+
+	if len(query) == 0 {
+		return nil, nil
+	}
+
+	labels := make(types.Labels, len(query))
+	for name := range query {
+		labels[name] = query.Get(name)
+	}
+
+	return labels, labels.Validate()
+}
+
+// Labels are signed as part of the series key, so unlabelled metrics keep
+// their old hashes.
 func checkHash(valueJSON types.ValueJSON, key string) bool {
 	hash, err := hex.DecodeString(valueJSON.Hash)
 	if err != nil {
@@ -352,11 +553,11 @@ func checkHash(valueJSON types.ValueJSON, key string) bool {
 
 	switch valueJSON.MType {
 	case GAUGE:
-		fmt.Fprintf(h, "%s:gauge:%f", valueJSON.ID, *valueJSON.Value)
+		fmt.Fprintf(h, "%s:gauge:%f", types.SeriesKey(valueJSON.ID, valueJSON.Labels), *valueJSON.Value)
 
 		return hmac.Equal(h.Sum(nil), hash)
 	case COUNTER:
-		fmt.Fprintf(h, "%s:counter:%d", valueJSON.ID, *valueJSON.Delta)
+		fmt.Fprintf(h, "%s:counter:%d", types.SeriesKey(valueJSON.ID, valueJSON.Labels), *valueJSON.Delta)
 
 		return hmac.Equal(h.Sum(nil), hash)
 	}
@@ -369,11 +570,11 @@ func calcHash(valueJSON types.ValueJSON, key string) string {
 
 	switch valueJSON.MType {
 	case GAUGE:
-		fmt.Fprintf(h, "%s:gauge:%f", valueJSON.ID, *valueJSON.Value)
+		fmt.Fprintf(h, "%s:gauge:%f", types.SeriesKey(valueJSON.ID, valueJSON.Labels), *valueJSON.Value)
 
 		return hex.EncodeToString(h.Sum(nil))
 	case COUNTER:
-		fmt.Fprintf(h, "%s:counter:%d", valueJSON.ID, *valueJSON.Delta)
+		fmt.Fprintf(h, "%s:counter:%d", types.SeriesKey(valueJSON.ID, valueJSON.Labels), *valueJSON.Delta)
 
 		return hex.EncodeToString(h.Sum(nil))
 	}
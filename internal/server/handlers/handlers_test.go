@@ -3,12 +3,14 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
@@ -22,8 +24,9 @@ func getConfig() *config.Config {
 	serverConfig := &config.Config{}
 	serverConfig.Address = "localhost:8080"
 	serverConfig.Restore = true
-	serverConfig.StoreInterval = "300s"
+	serverConfig.StoreInterval = 300 * time.Second
 	serverConfig.StoreFile = "/tmp/cmas-metrics-db.json"
+	serverConfig.BatchTTL = time.Hour
 
 	return serverConfig
 }
@@ -39,14 +42,30 @@ func (mr BrokenRepoInMemory) SaveAll(ctx context.Context, values []types.ValueJS
 	return nil
 }
 
-func (mr BrokenRepoInMemory) FindByName(ctx context.Context, name string) (types.Value, error) {
+func (mr BrokenRepoInMemory) SaveBatch(ctx context.Context, batch types.Batch) (bool, error) {
+	return true, nil
+}
+
+func (mr BrokenRepoInMemory) FindByName(ctx context.Context, name string, labels types.Labels) (types.Value, error) {
 	return types.Value{}, fmt.Errorf("metric with %s not found", name)
 }
 
-func (mr BrokenRepoInMemory) FindAll(ctx context.Context) (types.Values, error) {
+func (mr BrokenRepoInMemory) FindAll(ctx context.Context, filter types.Labels) (types.Values, error) {
 	return nil, errors.New("metrics not found")
 }
 
+func (mr BrokenRepoInMemory) FindSamples(ctx context.Context, id string, filter types.Labels, from, to time.Time) ([]types.Series, error) {
+	return nil, errors.New("samples not found")
+}
+
+func (mr BrokenRepoInMemory) QueryRange(ctx context.Context, query types.RangeQuery) ([]types.RangeSeries, error) {
+	return nil, errors.New("operation not allowed")
+}
+
+func (mr BrokenRepoInMemory) ExpireHistory(ctx context.Context) error {
+	return nil
+}
+
 func (mr BrokenRepoInMemory) Restore() error {
 	return nil
 }
@@ -874,6 +893,91 @@ func TestPing_WithBrokenRepository(t *testing.T) {
 	}
 }
 
+func TestQueryRange(t *testing.T) {
+	config := getConfig()
+	config.History.Retention = time.Hour
+	config.History.Samples = 10
+	repo := repositories.NewRepositoryInMemory(config)
+	ctx := context.Background()
+
+	for _, value := range []types.Gauge{1, 3, 2} {
+		require.NoError(t, repo.Save(ctx, "Alloc", types.Value{GValue: value, TValue: GAUGE, Labels: types.Labels{"host": "a"}}))
+		require.NoError(t, repo.Save(ctx, "Alloc", types.Value{GValue: 10 * value, TValue: GAUGE, Labels: types.Labels{"host": "b"}}))
+	}
+
+	require.NoError(t, repo.Save(ctx, "PollCount", types.Value{CValue: 1, TValue: COUNTER}))
+
+	from := time.Now().Add(-time.Minute).Unix()
+	to := time.Now().Add(time.Minute).Unix()
+
+	tests := []struct {
+		name       string
+		url        string
+		code       int
+		wantSeries int
+		wantValue  float64
+	}{
+		{name: "case 1", url: fmt.Sprintf("/api/v1/query_range?name=Alloc&from=%d&to=%d&step=1h", from, to), code: 200, wantSeries: 2, wantValue: 2},
+		{name: "case 2", url: fmt.Sprintf("/api/v1/query_range?name=Alloc&from=%d&to=%d&step=3600&agg=max&host=a", from, to), code: 200, wantSeries: 1, wantValue: 3},
+		{name: "case 3", url: fmt.Sprintf("/api/v1/query_range?name=Alloc&from=%d&to=%d&step=1h&host=c", from, to), code: 200, wantSeries: 0},
+		{name: "case 4", url: fmt.Sprintf("/api/v1/query_range?name=Alloc&from=%d&to=%d&step=1ms", from, to), code: 400},
+		{name: "case 5", url: fmt.Sprintf("/api/v1/query_range?name=Alloc&from=%d&to=%d&step=1h", to, from), code: 400},
+		{name: "case 6", url: fmt.Sprintf("/api/v1/query_range?name=Alloc&from=%d&step=1h&agg=median", from), code: 400},
+		{name: "case 7", url: "/api/v1/query_range?name=Alloc&from=yesterday&step=1h", code: 400},
+		{name: "case 8", url: "/api/v1/query_range?name=Alloc&from=NaN&step=1h", code: 400},
+		{name: "case 9", url: fmt.Sprintf("/api/v1/query_range?name=Alloc&from=%d&to=Inf&step=1h", from), code: 400},
+		{name: "case 10", url: fmt.Sprintf("/api/v1/query_range?name=Alloc&from=%d&to=%d&step=NaN", from, to), code: 400},
+		{name: "case 11", url: fmt.Sprintf("/api/v1/query_range?name=Alloc&from=%d&to=%d&step=1e300", from, to), code: 400},
+		{name: "case 12", url: fmt.Sprintf("/api/v1/query_range?name=Alloc&from=%d&to=%d&step=1h&agg=sum&host=a", from, to), code: 200, wantSeries: 1, wantValue: 6},
+		{name: "case 13", url: fmt.Sprintf("/api/v1/query_range?name=PollCount&from=%d&to=%d&step=1h&agg=sum", from, to), code: 400},
+	}
+
+	r := chi.NewRouter()
+	h := NewHandler(config, repo)
+	r.Get("/api/v1/query_range", h.QueryRange)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodGet, tt.url, bytes.NewBuffer(nil))
+			resp.Body.Close()
+			assert.Equal(t, tt.code, resp.StatusCode)
+			assert.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-type"))
+
+			if tt.code != 200 {
+				return
+			}
+
+			series := []types.RangeSeries{}
+			require.NoError(t, json.Unmarshal([]byte(body), &series))
+			require.Len(t, series, tt.wantSeries)
+
+			if tt.wantSeries == 0 {
+				return
+			}
+
+			assert.Equal(t, types.Labels{"host": "a"}, series[0].Labels)
+			require.Len(t, series[0].Points, 1)
+			assert.Equal(t, tt.wantValue, series[0].Points[0].Value)
+		})
+	}
+
+	resp, _ := testRequest(t, ts, http.MethodGet, fmt.Sprintf("/api/v1/query_range?name=Alloc&from=%d&step=1h", from), bytes.NewBuffer(nil))
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	r = chi.NewRouter()
+	h = NewHandler(config, BrokenRepoInMemory{})
+	r.Get("/api/v1/query_range", h.QueryRange)
+	broken := httptest.NewServer(r)
+	defer broken.Close()
+
+	resp, _ = testRequest(t, broken, http.MethodGet, fmt.Sprintf("/api/v1/query_range?name=Alloc&from=%d&step=1h", from), bytes.NewBuffer(nil))
+	resp.Body.Close()
+	assert.Equal(t, 500, resp.StatusCode)
+}
+
 func TestHashFunctions(t *testing.T) {
 	gaugeValue := float64(223.4)
 	deltaValue := int64(865)
@@ -0,0 +1,70 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const DefaultMaxBodySize = 10 << 20
+
+// Decompress transparently unpacks gzip encoded request bodies. The
+// decompressed body is limited to maxSize bytes so that a small payload can
+// not expand into an arbitrary amount of memory; larger bodies are rejected
+// with 413. Unknown encodings are rejected with 415.
+func Decompress(maxSize int64) func(next http.Handler) http.Handler {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBodySize
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+
+			switch encoding {
+			case "", "identity":
+				next.ServeHTTP(w, r)
+
+				return
+			case "gzip", "x-gzip":
+			default:
+				http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+
+				return
+			}
+
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "invalid gzip body", http.StatusBadRequest)
+
+				return
+			}
+			defer zr.Close()
+
+			body := &bytes.Buffer{}
+
+			n, err := io.Copy(body, io.LimitReader(zr, maxSize+1))
+			if err != nil {
+				http.Error(w, "invalid gzip body", http.StatusBadRequest)
+
+				return
+			}
+
+			if n > maxSize {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+
+				return
+			}
+
+			r.Body = io.NopCloser(body)
+			r.ContentLength = n
+			r.Header.Del("Content-Encoding")
+			r.Header.Set("Content-Length", strconv.FormatInt(n, 10))
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipBody(t *testing.T, data string) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	_, err := zw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	type want struct {
+		code int
+		body string
+	}
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		want     want
+	}{
+		{
+			name: "case 1",
+			body: []byte(`{"id":"Alloc"}`),
+			want: want{code: http.StatusOK, body: `{"id":"Alloc"}`},
+		},
+		{
+			name:     "case 2",
+			encoding: "gzip",
+			body:     gzipBody(t, `{"id":"Alloc"}`),
+			want:     want{code: http.StatusOK, body: `{"id":"Alloc"}`},
+		},
+		{
+			name:     "case 3",
+			encoding: "gzip",
+			body:     gzipBody(t, strings.Repeat("a", 65)),
+			want:     want{code: http.StatusRequestEntityTooLarge},
+		},
+		{
+			name:     "case 4",
+			encoding: "gzip",
+			body:     []byte("not gzip"),
+			want:     want{code: http.StatusBadRequest},
+		},
+		{
+			name:     "case 5",
+			encoding: "br",
+			body:     []byte("data"),
+			want:     want{code: http.StatusUnsupportedMediaType},
+		},
+	}
+
+	handler := Decompress(64)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Content-Encoding"))
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(body)), r.ContentLength)
+
+		w.Write(body)
+	}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				request.Header.Set("Content-Encoding", tt.encoding)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+
+			assert.Equal(t, tt.want.code, w.Code)
+			if tt.want.code == http.StatusOK {
+				assert.Equal(t, tt.want.body, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ustkit/cmas/internal/encryption"
+)
+
+type decryptedKey struct{}
+
+// Decrypt opens request bodies marked with the encryption header using
+// privateKey. It has to run before Decompress because agents compress the
+// payload before encrypting it. maxSize limits the encrypted body the same
+// way Decompress limits the decompressed one. Plain bodies pass through;
+// RequireEncryption rejects them on the routes that must not accept them.
+func Decrypt(privateKey *rsa.PrivateKey, maxSize int64) func(next http.Handler) http.Handler {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBodySize
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme := r.Header.Get(encryption.Header)
+			if scheme == "" {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			if scheme != encryption.Scheme {
+				http.Error(w, "unsupported encryption scheme", http.StatusUnsupportedMediaType)
+
+				return
+			}
+
+			if privateKey == nil {
+				http.Error(w, "encryption is not configured", http.StatusBadRequest)
+
+				return
+			}
+
+			message, err := io.ReadAll(io.LimitReader(r.Body, maxSize+1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+
+				return
+			}
+
+			if int64(len(message)) > maxSize {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+
+				return
+			}
+
+			body, err := encryption.Decrypt(privateKey, message)
+			if err != nil {
+				http.Error(w, "can not decrypt body", http.StatusBadRequest)
+
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Del(encryption.Header)
+			r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), decryptedKey{}, true)))
+		})
+	}
+}
+
+// RequireEncryption rejects request bodies Decrypt did not open when
+// privateKey is set, so clients can not downgrade the routes it guards to
+// plain text by leaving the encryption header out.
+func RequireEncryption(privateKey *rsa.PrivateKey) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decrypted, _ := r.Context().Value(decryptedKey{}).(bool)
+			if privateKey != nil && r.ContentLength != 0 && !decrypted {
+				http.Error(w, "request body must be encrypted", http.StatusBadRequest)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
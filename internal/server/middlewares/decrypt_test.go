@@ -0,0 +1,167 @@
+package middlewares
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/encryption"
+)
+
+func TestDecrypt(t *testing.T) {
+	privatePEM, publicPEM, err := encryption.GenerateKeys(2048)
+	require.NoError(t, err)
+	privateKey, err := encryption.ParsePrivateKey(privatePEM)
+	require.NoError(t, err)
+	publicKey, err := encryption.ParsePublicKey(publicPEM)
+	require.NoError(t, err)
+
+	plaintext := `[{"id":"Alloc","type":"gauge","value":3459}]`
+	message, err := encryption.Encrypt(publicKey, []byte(plaintext))
+	require.NoError(t, err)
+
+	type want struct {
+		code int
+		body string
+	}
+	tests := []struct {
+		name   string
+		scheme string
+		body   []byte
+		max    int64
+		want   want
+	}{
+		{
+			name: "case 1",
+			body: []byte(plaintext),
+			want: want{code: http.StatusOK, body: plaintext},
+		},
+		{
+			name:   "case 2",
+			scheme: encryption.Scheme,
+			body:   message,
+			want:   want{code: http.StatusOK, body: plaintext},
+		},
+		{
+			name:   "case 3",
+			scheme: encryption.Scheme,
+			body:   []byte(plaintext),
+			want:   want{code: http.StatusBadRequest},
+		},
+		{
+			name:   "case 4",
+			scheme: "rot13",
+			body:   message,
+			want:   want{code: http.StatusUnsupportedMediaType},
+		},
+		{
+			name:   "case 5",
+			scheme: encryption.Scheme,
+			body:   message,
+			max:    64,
+			want:   want{code: http.StatusRequestEntityTooLarge},
+		},
+		{
+			name: "case 6",
+			want: want{code: http.StatusOK, body: ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Decrypt(privateKey, tt.max)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Empty(t, r.Header.Get(encryption.Header))
+
+				body, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+
+				w.Write(body)
+			}))
+
+			request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			if tt.scheme != "" {
+				request.Header.Set(encryption.Header, tt.scheme)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+
+			assert.Equal(t, tt.want.code, w.Code)
+			if tt.want.code == http.StatusOK {
+				assert.Equal(t, tt.want.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDecrypt_WithoutKey(t *testing.T) {
+	plaintext := `[{"id":"Alloc","type":"gauge","value":3459}]`
+
+	handler := Decrypt(nil, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		w.Write(body)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader([]byte(plaintext))))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, plaintext, w.Body.String())
+}
+
+func TestRequireEncryption(t *testing.T) {
+	privatePEM, publicPEM, err := encryption.GenerateKeys(2048)
+	require.NoError(t, err)
+	privateKey, err := encryption.ParsePrivateKey(privatePEM)
+	require.NoError(t, err)
+	publicKey, err := encryption.ParsePublicKey(publicPEM)
+	require.NoError(t, err)
+
+	plaintext := `[{"id":"Alloc","type":"gauge","value":3459}]`
+	message, err := encryption.Encrypt(publicKey, []byte(plaintext))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		scheme   string
+		body     []byte
+		chunked  bool
+		wantCode int
+	}{
+		{name: "case 1", body: []byte(plaintext), wantCode: http.StatusBadRequest},
+		{name: "case 2", body: []byte(plaintext), chunked: true, wantCode: http.StatusBadRequest},
+		{name: "case 3", scheme: encryption.Scheme, body: message, wantCode: http.StatusOK},
+		{name: "case 4", wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Decrypt(privateKey, 0)(RequireEncryption(privateKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+			request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			if tt.scheme != "" {
+				request.Header.Set(encryption.Header, tt.scheme)
+			}
+
+			if tt.chunked {
+				request.ContentLength = -1
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+
+	w := httptest.NewRecorder()
+	RequireEncryption(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader([]byte(plaintext))))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,8 @@
+create table batches (
+    agent character varying not null,
+    id character varying not null,
+    applied_at timestamp with time zone not null default now(),
+    primary key (agent, id)
+);
+
+create index batches_applied_at_idx ON batches (applied_at);
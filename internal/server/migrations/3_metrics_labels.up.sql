@@ -0,0 +1,6 @@
+alter table metrics add column labels jsonb not null default '{}';
+
+alter table metrics drop constraint metrics_id_type_key;
+alter table metrics add constraint metrics_id_type_labels_key unique (id, type, labels);
+
+create index metrics_labels_idx ON metrics using gin (labels);
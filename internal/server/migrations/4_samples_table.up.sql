@@ -0,0 +1,12 @@
+create table samples (
+    id character varying not null,
+    type character varying not null,
+    labels jsonb not null default '{}',
+    time timestamp with time zone not null,
+    delta bigint not null default 0,
+    gauge double precision not null default 0
+) partition by range (time);
+
+create table samples_default partition of samples default;
+
+create index samples_id_time_idx ON samples (id, time);
@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/ustkit/cmas/internal/types"
+)
+
+// ring keeps the last size samples of a series, oldest at start. It grows up
+// to size and then overwrites the oldest sample. Samples that fall out of the
+// retention window are dropped on add and by expire, so a series that is
+// updated rarely or not at all does not hold on to them.
+type ring struct {
+	samples []types.Sample
+	start   int
+	count   int
+	size    int
+}
+
+func newRing(size int) *ring {
+	return &ring{size: size}
+}
+
+// add appends sample after dropping the samples taken before oldest.
+func (r *ring) add(sample types.Sample, oldest time.Time) {
+	r.expire(oldest)
+
+	switch {
+	case r.count < len(r.samples):
+		r.samples[(r.start+r.count)%len(r.samples)] = sample
+		r.count++
+	case len(r.samples) < r.size:
+		r.samples = append(r.ordered(), sample)
+		r.start = 0
+		r.count++
+	default:
+		r.samples[r.start] = sample
+		r.start = (r.start + 1) % len(r.samples)
+	}
+}
+
+func (r *ring) expire(oldest time.Time) {
+	for r.count > 0 && r.samples[r.start].Time.Before(oldest) {
+		r.start = (r.start + 1) % len(r.samples)
+		r.count--
+	}
+
+	if r.count == 0 {
+		r.samples, r.start = nil, 0
+	}
+}
+
+// ordered returns the samples oldest first, reusing the backing array when
+// they already start at its beginning.
+func (r *ring) ordered() []types.Sample {
+	if r.start == 0 {
+		return r.samples[:r.count]
+	}
+
+	samples := make([]types.Sample, 0, r.count+1)
+	for i := 0; i < r.count; i++ {
+		samples = append(samples, r.samples[(r.start+i)%len(r.samples)])
+	}
+
+	return samples
+}
+
+// between returns the samples taken in [from, to], oldest first.
+func (r *ring) between(from, to time.Time) []types.Sample {
+	samples := []types.Sample{}
+
+	for i := 0; i < r.count; i++ {
+		sample := r.samples[(r.start+i)%len(r.samples)]
+		if sample.Time.Before(from) || sample.Time.After(to) {
+			continue
+		}
+
+		samples = append(samples, sample)
+	}
+
+	return samples
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/ustkit/cmas/internal/server/config"
 	"github.com/ustkit/cmas/internal/types"
@@ -14,35 +16,66 @@ import (
 type RepoInMemory struct {
 	mutex   *sync.RWMutex
 	storage types.Values
+	batches *batchCache
+	history map[string]*ring
+	now     func() time.Time
 
 	config *config.Config
 }
 
+// batchCache remembers applied batch IDs for ttl. IDs are queued in the
+// order they were applied, so expired ones are always at the front.
+type batchCache struct {
+	ttl     time.Duration
+	now     func() time.Time
+	applied map[batchKey]bool
+	queue   []appliedBatch
+}
+
+// batchKey identifies a batch like the (agent, id) primary key of the
+// PostgreSQL batches table.
+type batchKey struct {
+	agent string
+	id    string
+}
+
+type appliedBatch struct {
+	key batchKey
+	at  time.Time
+}
+
 func NewRepositoryInMemory(serverConfig *config.Config) RepoInMemory {
 	return RepoInMemory{
 		mutex:   &sync.RWMutex{},
 		storage: make(types.Values),
+		batches: &batchCache{ttl: serverConfig.BatchTTL, now: time.Now, applied: make(map[batchKey]bool)},
+		history: make(map[string]*ring),
+		now:     time.Now,
 
 		config: serverConfig,
 	}
 }
 
 func (mr RepoInMemory) Save(ctx context.Context, name string, value types.Value) error {
+	key := types.SeriesKey(name, value.Labels)
+
 	mr.mutex.Lock()
 
-	if _, ok := mr.storage[name]; !ok {
-		mr.storage[name] = &value
+	if _, ok := mr.storage[key]; !ok {
+		mr.storage[key] = &value
+		mr.record(key)
 		mr.mutex.Unlock()
 
 		return nil
 	}
 
-	mr.storage[name].CValue += value.CValue
-	mr.storage[name].GValue = value.GValue
-	mr.storage[name].TValue = value.TValue
+	mr.storage[key].CValue += value.CValue
+	mr.storage[key].GValue = value.GValue
+	mr.storage[key].TValue = value.TValue
+	mr.record(key)
 	mr.mutex.Unlock()
 
-	if mr.config.StoreInterval == "0" {
+	if mr.config.StoreInterval == 0 {
 		return mr.SaveToFile()
 	}
 
@@ -51,7 +84,60 @@ func (mr RepoInMemory) Save(ctx context.Context, name string, value types.Value)
 
 func (mr RepoInMemory) SaveAll(ctx context.Context, values []types.ValueJSON) error {
 	mr.mutex.Lock()
+	mr.saveAll(values)
+	mr.mutex.Unlock()
+
+	if mr.config.StoreInterval == 0 {
+		return mr.SaveToFile()
+	}
+
+	return nil
+}
+
+func (mr RepoInMemory) SaveBatch(ctx context.Context, batch types.Batch) (bool, error) {
+	if batch.ID == "" {
+		return true, mr.SaveAll(ctx, batch.Values)
+	}
+
+	mr.mutex.Lock()
+
+	if !mr.batches.add(batchKey{agent: batch.Agent, id: batch.ID}) {
+		mr.mutex.Unlock()
+
+		return false, nil
+	}
+
+	mr.saveAll(batch.Values)
+	mr.mutex.Unlock()
+
+	if mr.config.StoreInterval == 0 {
+		return true, mr.SaveToFile()
+	}
+
+	return true, nil
+}
 
+// add records key and reports false if it was already applied.
+func (c *batchCache) add(key batchKey) bool {
+	now := c.now()
+
+	for len(c.queue) > 0 && now.Sub(c.queue[0].at) >= c.ttl {
+		delete(c.applied, c.queue[0].key)
+		c.queue = c.queue[1:]
+	}
+
+	if c.applied[key] {
+		return false
+	}
+
+	c.applied[key] = true
+	c.queue = append(c.queue, appliedBatch{key: key, at: now})
+
+	return true
+}
+
+// saveAll must be called with mr.mutex held.
+func (mr RepoInMemory) saveAll(values []types.ValueJSON) {
 	for _, value := range values {
 		var (
 			delta types.Counter
@@ -66,46 +152,187 @@ func (mr RepoInMemory) SaveAll(ctx context.Context, values []types.ValueJSON) er
 			gauge = *value.Value
 		}
 
-		if _, ok := mr.storage[value.ID]; !ok {
-			mr.storage[value.ID] = &types.Value{TValue: value.MType, CValue: delta, GValue: gauge}
+		key := types.SeriesKey(value.ID, value.Labels)
+
+		if _, ok := mr.storage[key]; !ok {
+			mr.storage[key] = &types.Value{TValue: value.MType, CValue: delta, GValue: gauge, Labels: value.Labels}
+			mr.record(key)
 
 			continue
 		}
 
-		mr.storage[value.ID].CValue += *value.Delta
-		mr.storage[value.ID].GValue = *value.Value
-		mr.storage[value.ID].TValue = value.MType
+		mr.storage[key].CValue += delta
+		mr.storage[key].GValue = gauge
+		mr.storage[key].TValue = value.MType
+		mr.record(key)
 	}
+}
 
-	mr.mutex.Unlock()
+// record adds the current value of key to its history. It must be called
+// with mr.mutex held.
+func (mr RepoInMemory) record(key string) {
+	if mr.config.History.Retention <= 0 {
+		return
+	}
 
-	if mr.config.StoreInterval == "0" {
-		return mr.SaveToFile()
+	history, ok := mr.history[key]
+	if !ok {
+		history = newRing(mr.config.History.Samples)
+		mr.history[key] = history
 	}
 
-	return nil
+	now := mr.now()
+	value := mr.storage[key]
+	history.add(types.Sample{Time: now, CValue: value.CValue, GValue: value.GValue}, now.Add(-mr.config.History.Retention))
 }
 
-func (mr RepoInMemory) FindByName(ctx context.Context, name string) (types.Value, error) {
+func (mr RepoInMemory) FindByName(ctx context.Context, name string, labels types.Labels) (types.Value, error) {
+	key := types.SeriesKey(name, labels)
+
 	mr.mutex.RLock()
 	defer mr.mutex.RUnlock()
-	value, ok := mr.storage[name]
+	value, ok := mr.storage[key]
+
+	if !ok && len(labels) == 0 {
+		value, ok = mr.only(name)
+	}
 
 	if !ok {
-		return types.Value{}, fmt.Errorf("metric %q not found", name)
+		return types.Value{}, fmt.Errorf("metric %q not found", key)
 	}
 
 	return *value, nil
 }
 
-func (mr RepoInMemory) FindAll(ctx context.Context) (values types.Values, err error) {
+// only returns the series of the metric name if it has exactly one. It must
+// be called with mr.mutex held.
+func (mr RepoInMemory) only(name string) (*types.Value, bool) {
+	var found *types.Value
+
+	for key, value := range mr.storage {
+		if types.SeriesID(key) != name {
+			continue
+		}
+
+		if found != nil {
+			return nil, false
+		}
+
+		found = value
+	}
+
+	return found, found != nil
+}
+
+func (mr RepoInMemory) FindAll(ctx context.Context, filter types.Labels) (values types.Values, err error) {
+	values = make(types.Values)
+
 	mr.mutex.RLock()
-	values = mr.storage
+
+	for key, value := range mr.storage {
+		if value.Labels.Match(filter) {
+			v := *value
+			values[key] = &v
+		}
+	}
+
 	mr.mutex.RUnlock()
 
 	return values, nil
 }
 
+// FindSamples expires the history of the matching series before reading
+// it, so samples past the retention are not returned even if the series has
+// not been updated since.
+func (mr RepoInMemory) FindSamples(ctx context.Context, id string, filter types.Labels, from, to time.Time) ([]types.Series, error) {
+	series := []types.Series{}
+	oldest := mr.now().Add(-mr.config.History.Retention)
+
+	mr.mutex.Lock()
+
+	for key, history := range mr.history {
+		value := mr.storage[key]
+		if types.SeriesID(key) != id || !value.Labels.Match(filter) {
+			continue
+		}
+
+		if !mr.expire(key, history, oldest) {
+			continue
+		}
+
+		samples := history.between(from, to)
+		if len(samples) == 0 {
+			continue
+		}
+
+		series = append(series, types.Series{ID: id, MType: value.TValue, Labels: value.Labels, Samples: samples})
+	}
+
+	mr.mutex.Unlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Labels.String() < series[j].Labels.String()
+	})
+
+	return series, nil
+}
+
+func (mr RepoInMemory) QueryRange(ctx context.Context, query types.RangeQuery) ([]types.RangeSeries, error) {
+	series, err := mr.FindSamples(ctx, query.ID, query.Labels, query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]types.RangeSeries, 0, len(series))
+	total := 0
+
+	for _, s := range series {
+		if err = query.CheckType(s.MType); err != nil {
+			return nil, err
+		}
+
+		aggregated := query.Aggregate(s)
+
+		total += len(aggregated.Points)
+		if err = query.CheckTotal(total); err != nil {
+			return nil, err
+		}
+
+		result = append(result, aggregated)
+	}
+
+	return result, nil
+}
+
+func (mr RepoInMemory) ExpireHistory(ctx context.Context) error {
+	oldest := mr.now().Add(-mr.config.History.Retention)
+
+	mr.mutex.Lock()
+
+	for key, history := range mr.history {
+		mr.expire(key, history, oldest)
+	}
+
+	mr.mutex.Unlock()
+
+	return nil
+}
+
+// expire drops the samples of key taken before oldest and the whole history
+// once it is empty, reporting whether any is left. It must be called with
+// mr.mutex held.
+func (mr RepoInMemory) expire(key string, history *ring, oldest time.Time) bool {
+	history.expire(oldest)
+
+	if history.count == 0 {
+		delete(mr.history, key)
+
+		return false
+	}
+
+	return true
+}
+
 func (mr RepoInMemory) Restore() (err error) {
 	if !mr.config.Restore || mr.config.StoreFile == "" {
 		return nil
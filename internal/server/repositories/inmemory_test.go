@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -14,7 +15,7 @@ func getConfig() *config.Config {
 	serverConfig := &config.Config{}
 	serverConfig.Address = "localhost:8080"
 	serverConfig.Restore = true
-	serverConfig.StoreInterval = "300s"
+	serverConfig.StoreInterval = 300 * time.Second
 	serverConfig.StoreFile = "/tmp/cmas-metrics-db.json"
 
 	return serverConfig
@@ -58,7 +59,7 @@ func TestRepoInMemory_Save(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			err := mr.Save(ctx, tt.metricName, tt.metricValue)
 			assert.Equal(t, tt.wantErrSave, err)
-			value, err := mr.FindByName(ctx, tt.metricName)
+			value, err := mr.FindByName(ctx, tt.metricName, nil)
 			assert.Equal(t, tt.wantErrFind, err)
 			if err == nil {
 				assert.Equal(t, value.GValue, tt.metricValue.GValue)
@@ -85,7 +86,7 @@ func TestRepoInMemory_FindByName(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			value, err := mr.FindByName(ctx, tt.metricName)
+			value, err := mr.FindByName(ctx, tt.metricName, nil)
 			assert.NotNil(t, err)
 			assert.Equal(t, tt.metricValue, value)
 		})
@@ -122,9 +123,249 @@ func TestRepoInMemory_FindAll(t *testing.T) {
 				err := mr.Save(ctx, metricName, *metricValue)
 				require.Nil(t, err)
 			}
-			findMetrics, err := mr.FindAll(ctx)
+			findMetrics, err := mr.FindAll(ctx, nil)
 			assert.NoError(t, tt.wantErr, err)
 			assert.Equal(t, tt.findMetrics, findMetrics)
 		})
 	}
 }
+
+func TestRepoInMemory_SaveBatch(t *testing.T) {
+	serverConfig := getConfig()
+	serverConfig.BatchTTL = time.Minute
+
+	mr := NewRepositoryInMemory(serverConfig)
+	now := time.Now()
+	mr.batches.now = func() time.Time { return now }
+
+	delta := types.Counter(2)
+	values := []types.ValueJSON{{ID: "PollCount", MType: "counter", Delta: &delta}}
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		batch       types.Batch
+		advance     time.Duration
+		wantApplied bool
+		wantTotal   types.Counter
+	}{
+		{name: "case 1", batch: types.Batch{Agent: "a", ID: "1", Values: values}, wantApplied: true, wantTotal: 2},
+		{name: "case 2", batch: types.Batch{Agent: "a", ID: "1", Values: values}, wantApplied: false, wantTotal: 2},
+		{name: "case 3", batch: types.Batch{Agent: "b", ID: "1", Values: values}, wantApplied: true, wantTotal: 4},
+		{name: "case 4", batch: types.Batch{Agent: "a", Values: values}, wantApplied: true, wantTotal: 6},
+		{name: "case 5", batch: types.Batch{Agent: "a", Values: values}, wantApplied: true, wantTotal: 8},
+		{name: "case 6", batch: types.Batch{Agent: "a", ID: "1", Values: values}, advance: 30 * time.Second, wantApplied: false, wantTotal: 8},
+		{name: "case 7", batch: types.Batch{Agent: "a", ID: "1", Values: values}, advance: time.Minute, wantApplied: true, wantTotal: 10},
+		{name: "case 8", batch: types.Batch{Agent: "x/y", ID: "z", Values: values}, wantApplied: true, wantTotal: 12},
+		{name: "case 9", batch: types.Batch{Agent: "x", ID: "y/z", Values: values}, wantApplied: true, wantTotal: 14},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+
+			applied, err := mr.SaveBatch(ctx, tt.batch)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantApplied, applied)
+
+			value, err := mr.FindByName(ctx, "PollCount", nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantTotal, value.CValue)
+		})
+	}
+}
+
+func TestRepoInMemory_Labels(t *testing.T) {
+	mr := NewRepositoryInMemory(getConfig())
+	ctx := context.Background()
+
+	gauge := types.Gauge(1)
+	require.NoError(t, mr.SaveAll(ctx, []types.ValueJSON{
+		{ID: "Alloc", MType: "gauge", Value: &gauge, Labels: types.Labels{"host": "a", "env": "prod"}},
+	}))
+	require.NoError(t, mr.Save(ctx, "Alloc", types.Value{GValue: 2, TValue: "gauge", Labels: types.Labels{"host": "b", "env": "prod"}}))
+	require.NoError(t, mr.Save(ctx, "Alloc", types.Value{GValue: 3, TValue: "gauge"}))
+
+	tests := []struct {
+		name      string
+		labels    types.Labels
+		wantValue types.Gauge
+		wantErr   bool
+	}{
+		{name: "case 1", labels: types.Labels{"host": "a", "env": "prod"}, wantValue: 1},
+		{name: "case 2", labels: types.Labels{"host": "b", "env": "prod"}, wantValue: 2},
+		{name: "case 3", labels: nil, wantValue: 3},
+		{name: "case 4", labels: types.Labels{"host": "a"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := mr.FindByName(ctx, "Alloc", tt.labels)
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantValue, value.GValue)
+		})
+	}
+
+	values, err := mr.FindAll(ctx, types.Labels{"env": "prod"})
+	require.NoError(t, err)
+	assert.Len(t, values, 2)
+	assert.Contains(t, values, `Alloc{env="prod",host="a"}`)
+
+	values, err = mr.FindAll(ctx, nil)
+	require.NoError(t, err)
+	assert.Len(t, values, 3)
+}
+
+func TestRepoInMemory_History(t *testing.T) {
+	serverConfig := getConfig()
+	serverConfig.History = config.History{Retention: time.Hour, Samples: 3}
+
+	mr := NewRepositoryInMemory(serverConfig)
+	start := time.Now()
+	now := start
+	mr.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 1; i <= 4; i++ {
+		require.NoError(t, mr.Save(ctx, "PollCount", types.Value{CValue: 2, TValue: "counter", Labels: types.Labels{"host": "a"}}))
+		require.NoError(t, mr.Save(ctx, "PollCount", types.Value{CValue: 1, TValue: "counter", Labels: types.Labels{"host": "b"}}))
+		now = now.Add(time.Minute)
+	}
+
+	value, err := mr.FindByName(ctx, "PollCount", types.Labels{"host": "a"})
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(8), value.CValue)
+
+	tests := []struct {
+		name        string
+		filter      types.Labels
+		from, to    time.Time
+		wantSeries  int
+		wantSamples []types.Counter
+	}{
+		{name: "case 1", filter: nil, from: start, to: now, wantSeries: 2, wantSamples: []types.Counter{4, 6, 8}},
+		{name: "case 2", filter: types.Labels{"host": "a"}, from: start, to: now, wantSeries: 1, wantSamples: []types.Counter{4, 6, 8}},
+		{name: "case 3", filter: types.Labels{"host": "a"}, from: start.Add(2 * time.Minute), to: start.Add(2 * time.Minute), wantSeries: 1, wantSamples: []types.Counter{6}},
+		{name: "case 4", filter: types.Labels{"host": "c"}, from: start, to: now, wantSeries: 0},
+		{name: "case 5", filter: nil, from: now.Add(time.Minute), to: now.Add(time.Hour), wantSeries: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series, err := mr.FindSamples(ctx, "PollCount", tt.filter, tt.from, tt.to)
+			require.NoError(t, err)
+			require.Len(t, series, tt.wantSeries)
+
+			if tt.wantSeries == 0 {
+				return
+			}
+
+			assert.Equal(t, types.Labels{"host": "a"}, series[0].Labels)
+
+			samples := []types.Counter{}
+			for _, sample := range series[0].Samples {
+				samples = append(samples, sample.CValue)
+			}
+
+			assert.Equal(t, tt.wantSamples, samples)
+		})
+	}
+
+	ranges, err := mr.QueryRange(ctx, types.RangeQuery{ID: "PollCount", Labels: types.Labels{"host": "b"}, From: start, To: now, Step: 2 * time.Minute, Agg: types.AggLast})
+	require.NoError(t, err)
+	require.Len(t, ranges, 1)
+	assert.Equal(t, []types.Point{{Time: start, Value: 2}, {Time: start.Add(2 * time.Minute), Value: 4}}, ranges[0].Points)
+
+	// Two series of two points each exceed a limit of three points in total.
+	_, err = mr.QueryRange(ctx, types.RangeQuery{ID: "PollCount", From: start, To: now, Step: 2 * time.Minute, Agg: types.AggLast, Limit: 3})
+	assert.ErrorIs(t, err, types.ErrInvalidQuery)
+
+	ranges, err = mr.QueryRange(ctx, types.RangeQuery{ID: "PollCount", From: start, To: now, Step: 2 * time.Minute, Agg: types.AggLast, Limit: 4})
+	require.NoError(t, err)
+	assert.Len(t, ranges, 2)
+
+	now = start.Add(time.Hour + 2*time.Minute + 30*time.Second)
+	series, err := mr.FindSamples(ctx, "PollCount", types.Labels{"host": "a"}, start, now)
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	assert.Len(t, series[0].Samples, 1)
+}
+
+func TestRepoInMemory_HistoryExpires(t *testing.T) {
+	serverConfig := getConfig()
+	serverConfig.History = config.History{Retention: time.Hour, Samples: 100}
+
+	mr := NewRepositoryInMemory(serverConfig)
+	start := time.Now()
+	now := start
+	mr.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, mr.Save(ctx, "Alloc", types.Value{GValue: types.Gauge(i), TValue: "gauge"}))
+		now = now.Add(20 * time.Minute)
+	}
+
+	// The sample taken at 0 minutes is out of the last hour when the one at
+	// 80 minutes is added.
+	history := mr.history[types.SeriesKey("Alloc", nil)]
+	require.NotNil(t, history)
+	assert.Equal(t, 4, history.count)
+
+	gauges := []types.Gauge{}
+	for _, sample := range history.between(start, now) {
+		gauges = append(gauges, sample.GValue)
+	}
+
+	assert.Equal(t, []types.Gauge{1, 2, 3, 4}, gauges)
+
+	// Wrapping around after an expiry keeps the order.
+	for i := 5; i < 8; i++ {
+		require.NoError(t, mr.Save(ctx, "Alloc", types.Value{GValue: types.Gauge(i), TValue: "gauge"}))
+		now = now.Add(20 * time.Minute)
+	}
+
+	gauges = gauges[:0]
+	for _, sample := range history.between(start, now) {
+		gauges = append(gauges, sample.GValue)
+	}
+
+	assert.Equal(t, []types.Gauge{4, 5, 6, 7}, gauges)
+}
+
+func TestRepoInMemory_HistoryIdle(t *testing.T) {
+	serverConfig := getConfig()
+	serverConfig.History = config.History{Retention: time.Hour, Samples: 100}
+
+	mr := NewRepositoryInMemory(serverConfig)
+	start := time.Now()
+	now := start
+	mr.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	require.NoError(t, mr.Save(ctx, "Alloc", types.Value{GValue: 1, TValue: "gauge", Labels: types.Labels{"process": "old"}}))
+	require.NoError(t, mr.Save(ctx, "Alloc", types.Value{GValue: 2, TValue: "gauge", Labels: types.Labels{"process": "new"}}))
+
+	// Only the new series keeps reporting.
+	now = start.Add(90 * time.Minute)
+	require.NoError(t, mr.Save(ctx, "Alloc", types.Value{GValue: 3, TValue: "gauge", Labels: types.Labels{"process": "new"}}))
+
+	series, err := mr.FindSamples(ctx, "Alloc", types.Labels{"process": "old"}, start, now)
+	require.NoError(t, err)
+	assert.Empty(t, series)
+	assert.NotContains(t, mr.history, types.SeriesKey("Alloc", types.Labels{"process": "old"}))
+
+	now = start.Add(3 * time.Hour)
+	require.NoError(t, mr.ExpireHistory(ctx))
+	assert.Empty(t, mr.history)
+
+	series, err = mr.FindSamples(ctx, "Alloc", nil, start, now)
+	require.NoError(t, err)
+	assert.Empty(t, series)
+}
@@ -3,8 +3,13 @@ package repositories
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	// Register pgx stdlib
 	_ "github.com/jackc/pgx/v4/stdlib"
@@ -15,16 +20,40 @@ import (
 
 var errNoDBConn = errors.New("no database connection")
 
+const (
+	upsertMetric = `INSERT INTO metrics (id, type, labels, delta, gauge) VALUES($1, $2, $3, $4, $5)  
+		 ON CONFLICT (id, type, labels) 
+		 DO UPDATE SET delta = metrics.delta + excluded.delta, gauge = $5`
+
+	// upsertMetricSample also stores the updated value as a sample taken at $6.
+	upsertMetricSample = `WITH updated AS (` + upsertMetric + `
+		 RETURNING id, type, labels, delta, gauge)
+		 INSERT INTO samples (id, type, labels, time, delta, gauge)
+		 SELECT id, type, labels, $6, delta, gauge FROM updated`
+
+	partitionPrefix = "samples_"
+	partitionLayout = "20060102"
+)
+
 type RepoPostgreSQL struct {
-	db     *sql.DB
-	config *config.Config
+	db         *sql.DB
+	config     *config.Config
+	partitions *partitions
+}
+
+// partitions remembers the day the samples partition was last created for,
+// so the DDL runs once a day rather than on every update.
+type partitions struct {
+	mu  *sync.Mutex
+	day time.Time
 }
 
 func NewRepositoryPostgreSQL(serverConfig *config.Config) (repo RepoPostgreSQL, err error) {
 	db, err := sql.Open("pgx", serverConfig.DataBaseDSN)
 	repo = RepoPostgreSQL{
-		db:     db,
-		config: serverConfig,
+		db:         db,
+		config:     serverConfig,
+		partitions: &partitions{mu: &sync.Mutex{}},
 	}
 
 	if err != nil {
@@ -39,16 +68,31 @@ func (repo RepoPostgreSQL) Save(ctx context.Context, name string, value types.Va
 		return errNoDBConn
 	}
 
-	_, err := repo.db.ExecContext(ctx,
-		`INSERT INTO metrics (id, type, delta, gauge) VALUES($1, $2, $3, $4)  
-		 ON CONFLICT (id, type) 
-		 DO UPDATE SET delta = metrics.delta + excluded.delta, gauge = $4`,
-		name, value.TValue, value.CValue, value.GValue)
+	labels, err := labelsJSON(value.Labels)
 	if err != nil {
 		return err
 	}
 
-	if repo.config.StoreInterval == "0" {
+	if repo.config.History.Retention > 0 {
+		now := time.Now().UTC()
+
+		err = repo.ensurePartition(ctx, now)
+		if err != nil {
+			return err
+		}
+
+		_, err = repo.db.ExecContext(ctx, upsertMetricSample,
+			name, value.TValue, labels, value.CValue, value.GValue, now)
+	} else {
+		_, err = repo.db.ExecContext(ctx, upsertMetric,
+			name, value.TValue, labels, value.CValue, value.GValue)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if repo.config.StoreInterval == 0 {
 		return repo.SaveToFile()
 	}
 
@@ -60,6 +104,13 @@ func (repo RepoPostgreSQL) SaveAll(ctx context.Context, values []types.ValueJSON
 		return errNoDBConn
 	}
 
+	now := time.Now().UTC()
+
+	err = repo.ensurePartition(ctx, now)
+	if err != nil {
+		return err
+	}
+
 	tx, err := repo.db.Begin()
 	if err != nil {
 		return err
@@ -73,10 +124,91 @@ func (repo RepoPostgreSQL) SaveAll(ctx context.Context, values []types.ValueJSON
 		}
 	}()
 
-	stmt, err := tx.PrepareContext(ctx,
-		`INSERT INTO metrics (id, type, delta, gauge) VALUES($1, $2, $3, $4)  
-		 ON CONFLICT (id, type) 
-		 DO UPDATE SET delta = metrics.delta + excluded.delta, gauge = $4`)
+	err = repo.saveAll(ctx, tx, values, now)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if repo.config.StoreInterval == 0 {
+		return repo.SaveToFile()
+	}
+
+	return nil
+}
+
+// SaveBatch records the batch ID and applies the values in one transaction;
+// a concurrent duplicate waits on the primary key and is then skipped.
+func (repo RepoPostgreSQL) SaveBatch(ctx context.Context, batch types.Batch) (applied bool, err error) {
+	if batch.ID == "" {
+		return true, repo.SaveAll(ctx, batch.Values)
+	}
+
+	if repo.db == nil {
+		return false, errNoDBConn
+	}
+
+	now := time.Now().UTC()
+
+	err = repo.ensurePartition(ctx, now)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		if (err != nil || !applied) && tx != nil {
+			if rbErr := tx.Rollback(); rbErr != nil && err != nil {
+				err = fmt.Errorf("save batch: tx err %w: roll back err %v", err, rbErr)
+			}
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM batches WHERE applied_at < $1`, now.Add(-repo.config.BatchTTL))
+	if err != nil {
+		return false, err
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO batches (agent, id) VALUES($1, $2) ON CONFLICT (agent, id) DO NOTHING`,
+		batch.Agent, batch.ID)
+	if err != nil {
+		return false, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil || inserted == 0 {
+		return false, err
+	}
+
+	err = repo.saveAll(ctx, tx, batch.Values, now)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (repo RepoPostgreSQL) saveAll(ctx context.Context, tx *sql.Tx, values []types.ValueJSON, now time.Time) error {
+	query := upsertMetric
+	if repo.config.History.Retention > 0 {
+		query = upsertMetricSample
+	}
+
+	stmt, err := tx.PrepareContext(ctx, query)
 	if err != nil {
 		return err
 	}
@@ -97,43 +229,110 @@ func (repo RepoPostgreSQL) SaveAll(ctx context.Context, values []types.ValueJSON
 			value = *v.Value
 		}
 
-		if _, err = stmt.ExecContext(ctx, v.ID, v.MType, delta, value); err != nil {
+		labels, err := labelsJSON(v.Labels)
+		if err != nil {
 			return err
 		}
-	}
 
-	err = tx.Commit()
-	if err != nil {
-		return err
-	}
+		args := []any{v.ID, v.MType, labels, delta, value}
+		if query == upsertMetricSample {
+			args = append(args, now)
+		}
 
-	if repo.config.StoreInterval == "0" {
-		return repo.SaveToFile()
+		if _, err = stmt.ExecContext(ctx, args...); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (repo RepoPostgreSQL) FindByName(ctx context.Context, name string) (value types.Value, err error) {
+func (repo RepoPostgreSQL) FindByName(ctx context.Context, name string, labels types.Labels) (value types.Value, err error) {
 	if repo.db == nil {
 		return value, errNoDBConn
 	}
 
+	labelsArg, err := labelsJSON(labels)
+	if err != nil {
+		return value, err
+	}
+
 	err = repo.db.QueryRowContext(ctx,
-		`SELECT type, delta, gauge FROM metrics WHERE id = $1`, name).
+		`SELECT type, delta, gauge FROM metrics WHERE id = $1 AND labels = $2::jsonb`, name, labelsArg).
 		Scan(&value.TValue, &value.CValue, &value.GValue)
+	if errors.Is(err, sql.ErrNoRows) && len(labels) == 0 {
+		return repo.findOnly(ctx, name)
+	}
 
-	return
+	if err != nil {
+		return value, err
+	}
+
+	if len(labels) > 0 {
+		value.Labels = labels
+	}
+
+	return value, nil
 }
 
-func (repo RepoPostgreSQL) FindAll(ctx context.Context) (values types.Values, err error) {
+// findOnly returns the series of the metric name if it has exactly one.
+func (repo RepoPostgreSQL) findOnly(ctx context.Context, name string) (value types.Value, err error) {
+	rows, err := repo.db.QueryContext(ctx,
+		`SELECT type, delta, gauge, labels FROM metrics WHERE id = $1 LIMIT 2`, name)
+	if err != nil {
+		return value, err
+	}
+
+	defer rows.Close()
+
+	found := 0
+
+	for rows.Next() {
+		var mLabels []byte
+
+		err = rows.Scan(&value.TValue, &value.CValue, &value.GValue, &mLabels)
+		if err != nil {
+			return value, err
+		}
+
+		err = json.Unmarshal(mLabels, &value.Labels)
+		if err != nil {
+			return value, err
+		}
+
+		found++
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return value, err
+	}
+
+	if found != 1 {
+		return types.Value{}, sql.ErrNoRows
+	}
+
+	if len(value.Labels) == 0 {
+		value.Labels = nil
+	}
+
+	return value, nil
+}
+
+func (repo RepoPostgreSQL) FindAll(ctx context.Context, filter types.Labels) (values types.Values, err error) {
 	if repo.db == nil {
 		return values, errNoDBConn
 	}
 
+	filterArg, err := labelsJSON(filter)
+	if err != nil {
+		return nil, err
+	}
+
 	values = make(types.Values)
 
-	rows, err := repo.db.QueryContext(ctx, "SELECT id, type, delta, gauge FROM metrics")
+	rows, err := repo.db.QueryContext(ctx,
+		"SELECT id, type, labels, delta, gauge FROM metrics WHERE labels @> $1::jsonb", filterArg)
 	if err != nil {
 		return nil, err
 	}
@@ -142,16 +341,26 @@ func (repo RepoPostgreSQL) FindAll(ctx context.Context) (values types.Values, er
 
 	for rows.Next() {
 		var (
-			mName  string
-			mValue types.Value
+			mName   string
+			mLabels []byte
+			mValue  types.Value
 		)
 
-		err = rows.Scan(&mName, &mValue.TValue, &mValue.CValue, &mValue.GValue)
+		err = rows.Scan(&mName, &mValue.TValue, &mLabels, &mValue.CValue, &mValue.GValue)
 		if err != nil {
 			return nil, err
 		}
 
-		values[mName] = &mValue
+		err = json.Unmarshal(mLabels, &mValue.Labels)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(mValue.Labels) == 0 {
+			mValue.Labels = nil
+		}
+
+		values[types.SeriesKey(mName, mValue.Labels)] = &mValue
 	}
 
 	err = rows.Err()
@@ -162,10 +371,294 @@ func (repo RepoPostgreSQL) FindAll(ctx context.Context) (values types.Values, er
 	return values, nil
 }
 
+func (repo RepoPostgreSQL) FindSamples(ctx context.Context, id string, filter types.Labels, from, to time.Time) ([]types.Series, error) {
+	if repo.db == nil {
+		return nil, errNoDBConn
+	}
+
+	filterArg, err := labelsJSON(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if oldest := time.Now().Add(-repo.config.History.Retention); from.Before(oldest) {
+		from = oldest
+	}
+
+	rows, err := repo.db.QueryContext(ctx,
+		`SELECT type, labels, time, delta, gauge FROM samples
+		 WHERE id = $1 AND labels @> $2::jsonb AND time BETWEEN $3 AND $4
+		 ORDER BY time`, id, filterArg, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	series := []types.Series{}
+	index := make(map[string]int)
+
+	for rows.Next() {
+		var (
+			mType   string
+			mLabels []byte
+			labels  types.Labels
+			sample  types.Sample
+		)
+
+		err = rows.Scan(&mType, &mLabels, &sample.Time, &sample.CValue, &sample.GValue)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(mLabels, &labels)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(labels) == 0 {
+			labels = nil
+		}
+
+		key := labels.String()
+
+		i, ok := index[key]
+		if !ok {
+			i = len(series)
+			index[key] = i
+			series = append(series, types.Series{ID: id, MType: mType, Labels: labels})
+		}
+
+		series[i].Samples = append(series[i].Samples, sample)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Labels.String() < series[j].Labels.String()
+	})
+
+	return series, nil
+}
+
+// aggregates maps range query aggregations to SQL over the sample value v.
+var aggregates = map[string]string{
+	types.AggAvg:  "avg(v)",
+	types.AggMin:  "min(v)",
+	types.AggMax:  "max(v)",
+	types.AggSum:  "sum(v)",
+	types.AggLast: "(array_agg(v ORDER BY time DESC))[1]",
+	types.AggP95:  "percentile_cont(0.95) WITHIN GROUP (ORDER BY v)",
+}
+
+// QueryRange buckets samples with date_bin, which aligns the steps to the
+// start of the range like RepoInMemory does. date_bin needs PostgreSQL 14
+// or later.
+func (repo RepoPostgreSQL) QueryRange(ctx context.Context, query types.RangeQuery) ([]types.RangeSeries, error) {
+	if repo.db == nil {
+		return nil, errNoDBConn
+	}
+
+	agg, ok := aggregates[query.Agg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown aggregation %q", types.ErrInvalidQuery, query.Agg)
+	}
+
+	filterArg, err := labelsJSON(query.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	from := query.From
+	if oldest := time.Now().Add(-repo.config.History.Retention); from.Before(oldest) {
+		from = oldest
+	}
+
+	// One row past the limit is enough to tell that it is exceeded.
+	var limit any
+	if query.Limit > 0 {
+		limit = query.Limit + 1
+	}
+
+	rows, err := repo.db.QueryContext(ctx, fmt.Sprintf(
+		`SELECT type, labels, step, %s FROM (
+		   SELECT type, labels, time, date_bin(make_interval(secs => $5), time, $6) AS step,
+		          CASE WHEN type = 'counter' THEN delta::double precision ELSE gauge END AS v
+		   FROM samples
+		   WHERE id = $1 AND labels @> $2::jsonb AND time BETWEEN $3 AND $4
+		 ) s
+		 GROUP BY type, labels, step
+		 ORDER BY step
+		 LIMIT $7`, agg),
+		query.ID, filterArg, from, query.To, query.Step.Seconds(), query.From, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	series := []types.RangeSeries{}
+	index := make(map[string]int)
+	total := 0
+
+	for rows.Next() {
+		total++
+		if err = query.CheckTotal(total); err != nil {
+			return nil, err
+		}
+
+		var (
+			mType   string
+			mLabels []byte
+			labels  types.Labels
+			point   types.Point
+		)
+
+		err = rows.Scan(&mType, &mLabels, &point.Time, &point.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = query.CheckType(mType); err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(mLabels, &labels)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(labels) == 0 {
+			labels = nil
+		}
+
+		key := labels.String()
+
+		i, ok := index[key]
+		if !ok {
+			i = len(series)
+			index[key] = i
+			series = append(series, types.RangeSeries{ID: query.ID, MType: mType, Labels: labels, Points: []types.Point{}})
+		}
+
+		series[i].Points = append(series[i].Points, point)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Labels.String() < series[j].Labels.String()
+	})
+
+	return series, nil
+}
+
+// ensurePartition creates the samples partition for the day of now and, once
+// a day, drops the partitions that fell out of the retention period.
+func (repo RepoPostgreSQL) ensurePartition(ctx context.Context, now time.Time) error {
+	if repo.config.History.Retention <= 0 {
+		return nil
+	}
+
+	day := now.Truncate(24 * time.Hour)
+
+	repo.partitions.mu.Lock()
+	defer repo.partitions.mu.Unlock()
+
+	if day.Equal(repo.partitions.day) {
+		return nil
+	}
+
+	_, err := repo.db.ExecContext(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s%s PARTITION OF samples FOR VALUES FROM ('%s') TO ('%s')`,
+		partitionPrefix, day.Format(partitionLayout), day.Format(time.RFC3339), day.Add(24*time.Hour).Format(time.RFC3339)))
+	if err != nil {
+		return err
+	}
+
+	err = repo.dropPartitions(ctx, now.Add(-repo.config.History.Retention))
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.db.ExecContext(ctx, `DELETE FROM samples_default WHERE time < $1`, now.Add(-repo.config.History.Retention))
+	if err != nil {
+		return err
+	}
+
+	repo.partitions.day = day
+
+	return nil
+}
+
+// dropPartitions drops the daily partitions that end before oldest.
+func (repo RepoPostgreSQL) dropPartitions(ctx context.Context, oldest time.Time) error {
+	rows, err := repo.db.QueryContext(ctx,
+		`SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		 WHERE i.inhparent = 'samples'::regclass`)
+	if err != nil {
+		return err
+	}
+
+	expired := []string{}
+
+	for rows.Next() {
+		var name string
+
+		err = rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+
+			return err
+		}
+
+		day, err := time.Parse(partitionLayout, strings.TrimPrefix(name, partitionPrefix))
+		if err != nil {
+			continue
+		}
+
+		if day.Add(24 * time.Hour).Before(oldest) {
+			expired = append(expired, name)
+		}
+	}
+
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range expired {
+		_, err = repo.db.ExecContext(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s`, name))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (repo RepoPostgreSQL) Restore() error {
 	return nil
 }
 
+// ExpireHistory drops the partitions that fell out of the retention period
+// even when no samples are written.
+func (repo RepoPostgreSQL) ExpireHistory(ctx context.Context) error {
+	if repo.db == nil {
+		return errNoDBConn
+	}
+
+	return repo.ensurePartition(ctx, time.Now())
+}
+
 func (repo RepoPostgreSQL) SaveToFile() error {
 	return nil
 }
@@ -185,3 +678,15 @@ func (repo RepoPostgreSQL) Ping(ctx context.Context) error {
 
 	return repo.db.PingContext(ctx)
 }
+
+// labelsJSON encodes labels for the jsonb labels column, where no labels
+// are stored as {}.
+func labelsJSON(labels types.Labels) (string, error) {
+	if len(labels) == 0 {
+		return "{}", nil
+	}
+
+	data, err := json.Marshal(labels)
+
+	return string(data), err
+}
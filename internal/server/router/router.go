@@ -1,17 +1,22 @@
 package router
 
 import (
+	"crypto/rsa"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/ustkit/cmas/internal/server/config"
 	"github.com/ustkit/cmas/internal/server/handlers"
+	"github.com/ustkit/cmas/internal/server/middlewares"
 	"github.com/ustkit/cmas/internal/types"
 )
 
-func NewRouter(serverConfig *config.Config, repo types.MetricRepo) chi.Router {
+func NewRouter(serverConfig *config.Config, repo types.MetricRepo, privateKey *rsa.PrivateKey) chi.Router {
 	r := chi.NewRouter()
 
 	r.Use(middleware.Compress(5))
+	r.Use(middlewares.Decrypt(privateKey, serverConfig.MaxBodySize))
+	r.Use(middlewares.Decompress(serverConfig.MaxBodySize))
 
 	h := handlers.NewHandler(serverConfig, repo)
 
@@ -20,11 +25,13 @@ func NewRouter(serverConfig *config.Config, repo types.MetricRepo) chi.Router {
 	r.Get("/ping", h.Ping)
 
 	r.Route("/update", func(r chi.Router) {
+		r.Use(middlewares.RequireEncryption(privateKey))
 		r.Post("/", h.UpdateJSON)
 		r.Post("/{type}/{name}/{value}", h.UpdatePlain)
 	})
 
 	r.Route("/updates", func(r chi.Router) {
+		r.Use(middlewares.RequireEncryption(privateKey))
 		r.Post("/", h.UpdateJSONBatch)
 	})
 
@@ -33,5 +40,9 @@ func NewRouter(serverConfig *config.Config, repo types.MetricRepo) chi.Router {
 		r.Get("/{type}/{name}", h.ValuePlain)
 	})
 
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Get("/query_range", h.QueryRange)
+	})
+
 	return r
 }
@@ -2,13 +2,16 @@ package router
 
 import (
 	"bytes"
+	"compress/gzip"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/ustkit/cmas/internal/encryption"
 	"github.com/ustkit/cmas/internal/server/config"
 	"github.com/ustkit/cmas/internal/server/repositories"
 )
@@ -17,7 +20,7 @@ func getConfig() *config.Config {
 	serverConfig := &config.Config{}
 	serverConfig.Address = "localhost:8080"
 	serverConfig.Restore = true
-	serverConfig.StoreInterval = "300s"
+	serverConfig.StoreInterval = 300 * time.Second
 	serverConfig.StoreFile = "/tmp/cmas-metrics-db.json"
 
 	return serverConfig
@@ -131,7 +134,7 @@ func TestRouterWithValidRepository(t *testing.T) {
 	}
 
 	config := getConfig()
-	r := NewRouter(config, repositories.NewRepositoryInMemory(config))
+	r := NewRouter(config, repositories.NewRepositoryInMemory(config), nil)
 	ts := httptest.NewServer(r)
 	defer ts.Close()
 
@@ -149,3 +152,127 @@ func TestRouterWithValidRepository(t *testing.T) {
 		})
 	}
 }
+
+func TestRouterGzipRequest(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	_, err := zw.Write([]byte(`[{"id":"Alloc","type":"gauge","value":3459},{"id":"PollCount","type":"counter","delta":5}]`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	config := getConfig()
+	r := NewRouter(config, repositories.NewRepositoryInMemory(config), nil)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", buf)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/value/counter/PollCount", bytes.NewBuffer(nil))
+	resp.Body.Close()
+	assert.Equal(t, "5\n", body)
+}
+
+func TestRouterLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "case 1", method: http.MethodPost, url: "/update/gauge/Alloc/1?host=a", wantCode: http.StatusOK},
+		{name: "case 2", method: http.MethodPost, url: "/update/", body: `{"id":"Alloc","type":"gauge","value":2,"labels":{"host":"b"}}`, wantCode: http.StatusOK},
+		{name: "case 3", method: http.MethodPost, url: "/updates/", body: `[{"id":"PollCount","type":"counter","delta":5,"labels":{"host":"a"}}]`, wantCode: http.StatusOK},
+		{name: "case 4", method: http.MethodGet, url: "/value/gauge/Alloc?host=a", wantCode: http.StatusOK, wantBody: "1\n"},
+		{name: "case 5", method: http.MethodGet, url: "/value/gauge/Alloc?host=b", wantCode: http.StatusOK, wantBody: "2\n"},
+		// Alloc has two series, so a lookup by name alone is ambiguous.
+		{name: "case 6", method: http.MethodGet, url: "/value/gauge/Alloc", wantCode: http.StatusNotFound},
+		{name: "case 12", method: http.MethodGet, url: "/value/counter/PollCount", wantCode: http.StatusOK, wantBody: "5\n"},
+		{name: "case 13", method: http.MethodPost, url: "/value/", body: `{"id":"PollCount","type":"counter"}`, wantCode: http.StatusOK, wantBody: `{"id":"PollCount","type":"counter","delta":5,"labels":{"host":"a"}}` + "\n"},
+		{name: "case 7", method: http.MethodPost, url: "/value/", body: `{"id":"PollCount","type":"counter","labels":{"host":"a"}}`, wantCode: http.StatusOK, wantBody: `{"id":"PollCount","type":"counter","delta":5,"labels":{"host":"a"}}` + "\n"},
+		{name: "case 8", method: http.MethodPost, url: "/update/gauge/Alloc/1?bad-name=a", wantCode: http.StatusBadRequest},
+		{name: "case 9", method: http.MethodPost, url: "/updates/", body: `[{"id":"Alloc","type":"gauge","value":1,"labels":{"":"a"}}]`, wantCode: http.StatusBadRequest},
+		{name: "case 10", method: http.MethodGet, url: "/api/v1/query_range?name=Alloc&from=2022-06-01T00:00:00Z&to=2022-06-02T00:00:00Z&step=1h&host=a", wantCode: http.StatusOK, wantBody: "[]\n"},
+		{name: "case 11", method: http.MethodGet, url: "/api/v1/query_range?from=2022-06-01T00:00:00Z&step=1h", wantCode: http.StatusBadRequest},
+	}
+
+	config := getConfig()
+	r := NewRouter(config, repositories.NewRepositoryInMemory(config), nil)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, tt.method, tt.url, bytes.NewBufferString(tt.body))
+			resp.Body.Close()
+
+			assert.Equal(t, tt.wantCode, resp.StatusCode)
+
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, body)
+			}
+		})
+	}
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/?host=a", bytes.NewBuffer(nil))
+	resp.Body.Close()
+	assert.Contains(t, body, `Alloc{host=&#34;a&#34;} = 1`)
+	assert.Contains(t, body, `PollCount{host=&#34;a&#34;} = 5`)
+	assert.NotContains(t, body, `host=&#34;b&#34;`)
+
+	resp, _ = testRequest(t, ts, http.MethodPost, "/update/", bytes.NewBufferString(`{"id":"Alloc","type":"gauge","value":3,"labels":{"host":"<script>alert(1)</script>"}}`))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/", bytes.NewBuffer(nil))
+	resp.Body.Close()
+	assert.Contains(t, body, `Alloc{host=&#34;&lt;script&gt;alert(1)&lt;/script&gt;&#34;} = 3`)
+	assert.NotContains(t, body, "<script>")
+}
+
+func TestRouterEncryption(t *testing.T) {
+	privatePEM, publicPEM, err := encryption.GenerateKeys(2048)
+	require.NoError(t, err)
+	privateKey, err := encryption.ParsePrivateKey(privatePEM)
+	require.NoError(t, err)
+	publicKey, err := encryption.ParsePublicKey(publicPEM)
+	require.NoError(t, err)
+
+	config := getConfig()
+	r := NewRouter(config, repositories.NewRepositoryInMemory(config), privateKey)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	batch := `[{"id":"Alloc","type":"gauge","value":3459}]`
+
+	resp, _ := testRequest(t, ts, http.MethodPost, "/updates/", bytes.NewBufferString(batch))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	message, err := encryption.Encrypt(publicKey, []byte(batch))
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", bytes.NewReader(message))
+	require.NoError(t, err)
+	req.Header.Set(encryption.Header, encryption.Scheme)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Reading values stays open to plain-text clients.
+	resp, body := testRequest(t, ts, http.MethodPost, "/value/", bytes.NewBufferString(`{"id":"Alloc","type":"gauge"}`))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"id":"Alloc","type":"gauge","value":3459}`+"\n", body)
+}
@@ -0,0 +1,121 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidLabels = errors.New("invalid labels")
+
+// Labels tell apart series of the same metric, e.g. the hosts reporting
+// it. A metric without labels is the series named just by its ID.
+type Labels map[string]string
+
+// ParseLabels parses "name=value" pairs separated by commas.
+func ParseLabels(s string) (Labels, error) {
+	labels := Labels{}
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q is not name=value", ErrInvalidLabels, pair)
+		}
+
+		labels[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return labels, labels.Validate()
+}
+
+// Validate checks that label names are identifiers ([A-Za-z_][A-Za-z0-9_]*),
+// which keeps series keys unambiguous.
+func (l Labels) Validate() error {
+	for name := range l {
+		if !validLabelName(name) {
+			return fmt.Errorf("%w: bad label name %q", ErrInvalidLabels, name)
+		}
+	}
+
+	return nil
+}
+
+func validLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// Match reports whether l has every label of filter.
+func (l Labels) Match(filter Labels) bool {
+	for name, value := range filter {
+		if v, ok := l[name]; !ok || v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String formats the labels as {a="1",b="2"} sorted by name, or "" when
+// there are none.
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	b := strings.Builder{}
+	b.WriteByte('{')
+
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(l[name]))
+	}
+
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// SeriesKey identifies a series in storage: the metric ID followed by its
+// labels. Unlabelled metrics keep their plain ID as the key.
+func SeriesKey(id string, labels Labels) string {
+	return id + labels.String()
+}
+
+// SeriesID returns the metric ID part of a series key.
+func SeriesID(key string) string {
+	id, _, _ := strings.Cut(key, "{")
+
+	return id
+}
@@ -0,0 +1,56 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Labels
+		wantErr bool
+	}{
+		{name: "case 1", value: "host=web1, env=prod", want: Labels{"host": "web1", "env": "prod"}},
+		{name: "case 2", value: "", want: Labels{}},
+		{name: "case 3", value: "host", wantErr: true},
+		{name: "case 4", value: "1host=web1", wantErr: true},
+		{name: "case 5", value: "host-name=web1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels, err := ParseLabels(tt.value)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidLabels)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, labels)
+		})
+	}
+}
+
+func TestSeriesKey(t *testing.T) {
+	assert.Equal(t, "Alloc", SeriesKey("Alloc", nil))
+	assert.Equal(t, `Alloc{env="prod",host="web1"}`, SeriesKey("Alloc", Labels{"host": "web1", "env": "prod"}))
+	assert.Equal(t, `Alloc{host="a,b=\"c\""}`, SeriesKey("Alloc", Labels{"host": `a,b="c"`}))
+
+	assert.Equal(t, "Alloc", SeriesID(`Alloc{host="web1"}`))
+	assert.Equal(t, "Alloc", SeriesID("Alloc"))
+}
+
+func TestLabels_Match(t *testing.T) {
+	labels := Labels{"host": "web1", "env": "prod"}
+
+	assert.True(t, labels.Match(nil))
+	assert.True(t, labels.Match(Labels{"host": "web1"}))
+	assert.False(t, labels.Match(Labels{"host": "web2"}))
+	assert.False(t, labels.Match(Labels{"dc": "eu"}))
+	assert.False(t, Labels(nil).Match(Labels{"host": "web1"}))
+}
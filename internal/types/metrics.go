@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 type Counter int64
 
 type Gauge float64
@@ -8,14 +10,47 @@ type Value struct {
 	CValue Counter `json:"delta,omitempty"`
 	GValue Gauge   `json:"value,omitempty"`
 	TValue string  `json:"type"`
+	Labels Labels  `json:"labels,omitempty"`
 }
 
 type Values map[string]*Value
 
 type ValueJSON struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *Counter `json:"delta,omitempty"`
-	Value *Gauge   `json:"value,omitempty"`
-	Hash  string   `json:"hash,omitempty"`
+	ID     string   `json:"id"`
+	MType  string   `json:"type"`
+	Delta  *Counter `json:"delta,omitempty"`
+	Value  *Gauge   `json:"value,omitempty"`
+	Hash   string   `json:"hash,omitempty"`
+	Labels Labels   `json:"labels,omitempty"`
+}
+
+// Agents tag each batch with these headers; the server applies a batch
+// once per agent and batch ID, so retried requests do not double-count.
+const (
+	AgentIDHeader = "X-Agent-ID"
+	BatchIDHeader = "X-Batch-ID"
+)
+
+// Batch is a set of updates sent at once. A batch without an ID is always
+// applied.
+type Batch struct {
+	Agent  string
+	ID     string
+	Values []ValueJSON
+}
+
+// Sample is a series value at the time an update was applied. Counters are
+// sampled as their running total.
+type Sample struct {
+	Time   time.Time `json:"time"`
+	CValue Counter   `json:"delta,omitempty"`
+	GValue Gauge     `json:"value,omitempty"`
+}
+
+// Series is the history of one series, oldest sample first.
+type Series struct {
+	ID      string   `json:"id"`
+	MType   string   `json:"type"`
+	Labels  Labels   `json:"labels,omitempty"`
+	Samples []Sample `json:"samples"`
 }
@@ -0,0 +1,185 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+var ErrInvalidQuery = errors.New("invalid range query")
+
+// Aggregations a range query can reduce each step to.
+const (
+	AggAvg  = "avg"
+	AggMin  = "min"
+	AggMax  = "max"
+	AggSum  = "sum"
+	AggLast = "last"
+	AggP95  = "p95"
+)
+
+// RangeQuery asks for the series of a metric having all the Labels, reduced
+// to one point per Step between From and To. Steps are aligned to From.
+// Limit caps the points of all the series together, 0 means no limit.
+type RangeQuery struct {
+	ID     string
+	Labels Labels
+	From   time.Time
+	To     time.Time
+	Step   time.Duration
+	Agg    string
+	Limit  int
+}
+
+// Point is the aggregate of the samples in the step starting at Time.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// RangeSeries is the result of a range query for one series. Steps without
+// samples have no point.
+type RangeSeries struct {
+	ID     string  `json:"id"`
+	MType  string  `json:"type"`
+	Labels Labels  `json:"labels,omitempty"`
+	Points []Point `json:"points"`
+}
+
+// Validate checks the query and that it spans at most maxPoints steps.
+func (q RangeQuery) Validate(maxPoints int) error {
+	switch {
+	case q.ID == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidQuery)
+	case !q.To.After(q.From):
+		return fmt.Errorf("%w: to must be after from", ErrInvalidQuery)
+	case q.Step <= 0:
+		return fmt.Errorf("%w: step must be positive", ErrInvalidQuery)
+	case q.Points() > maxPoints:
+		return fmt.Errorf("%w: %d points exceed the limit of %d, use a larger step", ErrInvalidQuery, q.Points(), maxPoints)
+	}
+
+	switch q.Agg {
+	case AggAvg, AggMin, AggMax, AggSum, AggLast, AggP95:
+	default:
+		return fmt.Errorf("%w: unknown aggregation %q", ErrInvalidQuery, q.Agg)
+	}
+
+	return q.Labels.Validate()
+}
+
+// Points returns the number of steps between From and To.
+func (q RangeQuery) Points() int {
+	return int(q.To.Sub(q.From)/q.Step) + 1
+}
+
+// CheckType rejects aggregations that make no sense for the series type:
+// counter samples are running totals, so summing them counts every
+// increment many times over.
+func (q RangeQuery) CheckType(mType string) error {
+	if q.Agg == AggSum && mType == "counter" {
+		return fmt.Errorf("%w: sum of counter totals is meaningless, use last or max", ErrInvalidQuery)
+	}
+
+	return nil
+}
+
+// CheckTotal fails once total points exceed the Limit of the query, so the
+// repositories can stop reading instead of building an unbounded result.
+func (q RangeQuery) CheckTotal(total int) error {
+	if q.Limit > 0 && total > q.Limit {
+		return fmt.Errorf("%w: more than %d points in total, narrow the label filter or use a larger step", ErrInvalidQuery, q.Limit)
+	}
+
+	return nil
+}
+
+// Aggregate reduces the samples of a series, oldest first, to points.
+// Counter samples are aggregated as their running totals.
+func (q RangeQuery) Aggregate(series Series) RangeSeries {
+	result := RangeSeries{ID: series.ID, MType: series.MType, Labels: series.Labels, Points: []Point{}}
+	bucket := []float64{}
+	start := time.Time{}
+
+	flush := func() {
+		if len(bucket) > 0 {
+			result.Points = append(result.Points, Point{Time: start, Value: aggregate(q.Agg, bucket)})
+		}
+	}
+
+	for _, sample := range series.Samples {
+		if sample.Time.Before(q.From) || sample.Time.After(q.To) {
+			continue
+		}
+
+		step := q.From.Add(sample.Time.Sub(q.From) / q.Step * q.Step)
+		if !step.Equal(start) {
+			flush()
+
+			start = step
+			bucket = bucket[:0]
+		}
+
+		value := float64(sample.GValue)
+		if series.MType == "counter" {
+			value = float64(sample.CValue)
+		}
+
+		bucket = append(bucket, value)
+	}
+
+	flush()
+
+	return result
+}
+
+func aggregate(agg string, values []float64) float64 {
+	switch agg {
+	case AggMin:
+		min := values[0]
+		for _, v := range values[1:] {
+			min = math.Min(min, v)
+		}
+
+		return min
+	case AggMax:
+		max := values[0]
+		for _, v := range values[1:] {
+			max = math.Max(max, v)
+		}
+
+		return max
+	case AggSum, AggAvg:
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+
+		if agg == AggAvg {
+			return sum / float64(len(values))
+		}
+
+		return sum
+	case AggLast:
+		return values[len(values)-1]
+	case AggP95:
+		return percentile(values, 0.95)
+	}
+
+	return 0
+}
+
+// percentile interpolates between the closest ranks like PostgreSQL's
+// percentile_cont, so both repositories agree.
+func percentile(values []float64, q float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	pos := q * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
@@ -0,0 +1,77 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangeQuery_Validate(t *testing.T) {
+	from := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	valid := RangeQuery{ID: "Alloc", From: from, To: from.Add(time.Hour), Step: time.Minute, Agg: AggAvg}
+
+	tests := []struct {
+		name    string
+		modify  func(q *RangeQuery)
+		wantErr bool
+	}{
+		{name: "case 1", modify: func(q *RangeQuery) {}},
+		{name: "case 2", modify: func(q *RangeQuery) { q.ID = "" }, wantErr: true},
+		{name: "case 3", modify: func(q *RangeQuery) { q.To = q.From }, wantErr: true},
+		{name: "case 4", modify: func(q *RangeQuery) { q.Step = 0 }, wantErr: true},
+		{name: "case 5", modify: func(q *RangeQuery) { q.Step = time.Second }, wantErr: true},
+		{name: "case 6", modify: func(q *RangeQuery) { q.Agg = "median" }, wantErr: true},
+		{name: "case 7", modify: func(q *RangeQuery) { q.Labels = Labels{"1host": "a"} }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := valid
+			tt.modify(&query)
+
+			err := query.Validate(100)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestRangeQuery_Aggregate(t *testing.T) {
+	from := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	series := Series{ID: "Alloc", MType: "gauge", Samples: []Sample{
+		{Time: from.Add(-time.Second), GValue: 100},
+		{Time: from, GValue: 1},
+		{Time: from.Add(10 * time.Second), GValue: 3},
+		{Time: from.Add(20 * time.Second), GValue: 2},
+		{Time: from.Add(2 * time.Minute), GValue: 5},
+	}}
+
+	tests := []struct {
+		name string
+		agg  string
+		want []float64
+	}{
+		{name: "case 1", agg: AggAvg, want: []float64{2, 5}},
+		{name: "case 2", agg: AggMin, want: []float64{1, 5}},
+		{name: "case 3", agg: AggMax, want: []float64{3, 5}},
+		{name: "case 4", agg: AggSum, want: []float64{6, 5}},
+		{name: "case 5", agg: AggLast, want: []float64{2, 5}},
+		{name: "case 6", agg: AggP95, want: []float64{2.9, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := RangeQuery{ID: "Alloc", From: from, To: from.Add(time.Hour), Step: time.Minute, Agg: tt.agg}
+			result := query.Aggregate(series)
+
+			assert.Len(t, result.Points, len(tt.want))
+
+			for i, point := range result.Points {
+				assert.InDelta(t, tt.want[i], point.Value, 1e-9)
+			}
+
+			assert.Equal(t, from, result.Points[0].Time)
+			assert.Equal(t, from.Add(2*time.Minute), result.Points[1].Time)
+		})
+	}
+}
@@ -1,12 +1,32 @@
 package types
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type MetricRepo interface {
 	Save(context.Context, string, Value) error
 	SaveAll(context.Context, []ValueJSON) error
-	FindByName(context.Context, string) (Value, error)
-	FindAll(context.Context) (Values, error)
+	// SaveBatch is SaveAll that skips batches already applied within the
+	// batch TTL and reports whether the values were applied.
+	SaveBatch(context.Context, Batch) (bool, error)
+	// FindByName returns the series with exactly these labels. Without
+	// labels it falls back to the only series of the metric, if there is
+	// just one, so clients that predate labels can still read values.
+	FindByName(context.Context, string, Labels) (Value, error)
+	// FindAll returns the series having all the filter labels, keyed by
+	// SeriesKey.
+	FindAll(context.Context, Labels) (Values, error)
+	// FindSamples returns the history between from and to of the series of
+	// a metric that have all the filter labels, sorted by SeriesKey.
+	FindSamples(ctx context.Context, id string, filter Labels, from, to time.Time) ([]Series, error)
+	// QueryRange aggregates the history of the series matching the query,
+	// sorted by SeriesKey.
+	QueryRange(context.Context, RangeQuery) ([]RangeSeries, error)
+	// ExpireHistory drops the samples older than the history retention,
+	// including those of series that are no longer updated.
+	ExpireHistory(context.Context) error
 	Restore() error
 	SaveToFile() error
 	Close() error